@@ -0,0 +1,367 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+const (
+	// the default duration the target must be down before alerting
+	defaultTargetDownFor = "5m"
+	// the default duration the target must return no samples before alerting
+	defaultNoSamplesFor = "10m"
+	// the default duration the scrape duration must be high before alerting
+	defaultScrapeDurationFor = "5m"
+	// the default severity of the alerts
+	defaultAlertSeverity = "warning"
+	// the default scrape timeout of prometheus
+	defaultScrapeTimeout = "10s"
+)
+
+// ruleFile is a prometheus rule file
+type ruleFile struct {
+	// the groups of rules
+	Groups []*ruleGroup `yaml:"groups" json:"groups"`
+}
+
+// ruleGroup is a group of rules within the rule file
+type ruleGroup struct {
+	// the name of the group
+	Name string `yaml:"name" json:"name"`
+	// the rules of the group
+	Rules []*AlertRule `yaml:"rules" json:"rules"`
+}
+
+// validateAlertsFilePattern checks the pattern used to split the alerting rules into files
+func validateAlertsFilePattern(pattern string) error {
+	if !strings.Contains(pattern, patternNamespace) {
+		return fmt.Errorf("the alerts file pattern: %s must contain %s", pattern, patternNamespace)
+	}
+	if strings.HasPrefix(pattern, "{") {
+		return fmt.Errorf("the alerts file pattern: %s must start with a literal prefix, i.e. alerts-", pattern)
+	}
+	if strings.Contains(pattern, "/") {
+		return fmt.Errorf("the alerts file pattern: %s must not contain a directory", pattern)
+	}
+	remaining := strings.NewReplacer(patternNamespace, "", patternService, "").Replace(pattern)
+	if strings.ContainsAny(remaining, "{}*?[]") {
+		return fmt.Errorf("the alerts file pattern: %s contains an unknown placeholder or glob character", pattern)
+	}
+
+	return nil
+}
+
+// alertsPatternOverlaps checks if the rule files could collide with the target files of the
+// configuration, the literal prefix of the pattern must not overlap with those of the target files
+func alertsPatternOverlaps(c *Config) bool {
+	prefix := getFilenamePrefix(c.AlertsFilePattern)
+	targets := []string{c.NodesConfigFilename, c.PodsConfigFilename}
+	if c.PodsFilePattern != "" {
+		targets = append(targets, getFilenamePrefix(c.PodsFilePattern))
+	}
+	if c.Shards > 0 {
+		targets = append(targets, getFilenamePrefix(shardFilename))
+	}
+	for _, filename := range targets {
+		if strings.HasPrefix(filename, prefix) || strings.HasPrefix(prefix, filename) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getAlertsFilename returns the name of the file the alerting rules of the service are written to
+func getAlertsFilename(namespace, service string) string {
+	replacer := strings.NewReplacer(patternNamespace, namespace, patternService, service)
+
+	return getOutputFilename(replacer.Replace(getConfig().AlertsFilePattern))
+}
+
+// getAlertsFilenameGlob returns a glob matching all of the rule files, used for the rule_files
+func getAlertsFilenameGlob() string {
+	return getAlertsFilename("*", "*")
+}
+
+// isAlertsFile checks if the file is one of the rule files
+func isAlertsFile(filename string) bool {
+	return getConfig().AlertsFilePattern != "" && getFilenameRegex(getAlertsFilename(patternNamespace, patternService)).MatchString(filename)
+}
+
+// durationSeconds converts a prometheus duration into seconds
+func durationSeconds(duration string) (float64, error) {
+	if !durationRegex.MatchString(duration) {
+		return 0, fmt.Errorf("invalid duration: %s", duration)
+	}
+	units := map[string]time.Duration{
+		"ms": time.Millisecond, "s": time.Second, "m": time.Minute, "h": time.Hour,
+		"d": 24 * time.Hour, "w": 7 * 24 * time.Hour, "y": 365 * 24 * time.Hour,
+	}
+	unit := strings.TrimLeft(duration, "0123456789")
+	value, err := strconv.Atoi(strings.TrimSuffix(duration, unit))
+	if err != nil {
+		return 0, err
+	}
+
+	return (time.Duration(value) * units[unit]).Seconds(), nil
+}
+
+// validateAlerts checks the alerts of the metrics entry, returning the problems found
+func validateAlerts(metric *Metrics) []string {
+	var list []string
+	alerts := metric.Alerts
+	for name, alert := range map[string]*BasicAlert{"target_down": alerts.TargetDown, "no_samples": alerts.NoSamples, "scrape_duration": alerts.ScrapeDuration} {
+		if alert == nil {
+			continue
+		}
+		if alert.For != "" && !durationRegex.MatchString(alert.For) {
+			list = append(list, fmt.Sprintf("alerts: %s: invalid for: %s", name, alert.For))
+		}
+		if alert.Threshold != "" && (name != "scrape_duration" || !durationRegex.MatchString(alert.Threshold)) {
+			list = append(list, fmt.Sprintf("alerts: %s: invalid threshold: %s", name, alert.Threshold))
+		}
+	}
+	for i, rule := range alerts.Rules {
+		entry := fmt.Sprintf("alerts: rule %d", i+1)
+		if rule.Alert != "" {
+			entry = fmt.Sprintf("alerts: rule %d (%s)", i+1, rule.Alert)
+		}
+		if rule.Alert == "" || sanitizeLabelName(rule.Alert) != rule.Alert {
+			list = append(list, fmt.Sprintf("%s: invalid alert name: %q", entry, rule.Alert))
+		}
+		if err := checkExpression(rule.Expr); err != nil {
+			list = append(list, fmt.Sprintf("%s: invalid expression: %s", entry, err))
+		}
+		if rule.For != "" && !durationRegex.MatchString(rule.For) {
+			list = append(list, fmt.Sprintf("%s: invalid for: %s", entry, rule.For))
+		}
+	}
+	sort.Strings(list)
+
+	return list
+}
+
+// checkExpression parses the prometheus expression, using the same parser as prometheus when it loads
+// the rules, so an invalid expression is caught before it reaches prometheus
+func checkExpression(expression string) error {
+	if strings.TrimSpace(expression) == "" {
+		return fmt.Errorf("the expression is empty")
+	}
+	if _, err := parser.ParseExpr(expression); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// alertSelector returns the selector of the series of the targets of the metrics entry, matching
+// on the same labels and job names as the target output
+func alertSelector(key serviceKey, metric *Metrics) string {
+	var matchers []string
+	job := ""
+	if getConfig().ScrapeConfigBase != "" {
+		job = "pods"
+		if getConfig().ScrapeJobs == scrapeJobsPerService {
+			job = serviceJobName(key, metric)
+		}
+	}
+	// step: the job label set on the targets takes precedence over the name of the scrape job
+	if getConfig().EndpointLabel == "job" {
+		job = metricName(metric)
+	}
+	if job != "" {
+		matchers = append(matchers, fmt.Sprintf("job=%q", job))
+	}
+	matchers = append(matchers,
+		fmt.Sprintf("namespace=%q", key.namespace),
+		fmt.Sprintf("pod=%q", key.name),
+		fmt.Sprintf("instance=~%q", fmt.Sprintf(".+:%d", metric.Port)))
+
+	return "{" + strings.Join(matchers, ",") + "}"
+}
+
+// newBasicAlertRule creates one of the basic alerting rules
+func newBasicAlertRule(alert *BasicAlert, name, expr, duration, summary string) *AlertRule {
+	rule := &AlertRule{
+		Alert:       name,
+		Expr:        expr,
+		For:         duration,
+		Labels:      map[string]string{"severity": defaultAlertSeverity},
+		Annotations: map[string]string{"summary": summary},
+	}
+	if alert.For != "" {
+		rule.For = alert.For
+	}
+	if alert.Severity != "" {
+		rule.Labels["severity"] = alert.Severity
+	}
+
+	return rule
+}
+
+// generateAlertRules generates the alerting rules of the metrics entry, which must be valid
+func generateAlertRules(key serviceKey, metric *Metrics) []*AlertRule {
+	var rules []*AlertRule
+	alerts := metric.Alerts
+	selector := alertSelector(key, metric)
+	service := fmt.Sprintf("%s/%s", key.namespace, key.name)
+
+	if alerts.TargetDown != nil {
+		rules = append(rules, newBasicAlertRule(alerts.TargetDown, "TargetDown", "up"+selector+" == 0", defaultTargetDownFor,
+			fmt.Sprintf("the target {{ $labels.instance }} of %s is down", service)))
+	}
+	if alerts.NoSamples != nil {
+		rules = append(rules, newBasicAlertRule(alerts.NoSamples, "NoSamples", "scrape_samples_scraped"+selector+" == 0", defaultNoSamplesFor,
+			fmt.Sprintf("the target {{ $labels.instance }} of %s has returned no samples", service)))
+	}
+	if alerts.ScrapeDuration != nil {
+		// step: the threshold defaults to half the scrape timeout
+		threshold, _ := durationSeconds(alerts.ScrapeDuration.Threshold)
+		if alerts.ScrapeDuration.Threshold == "" {
+			timeout := metric.Timeout
+			if timeout == "" || !durationRegex.MatchString(timeout) {
+				timeout = defaultScrapeTimeout
+			}
+			threshold, _ = durationSeconds(timeout)
+			threshold = threshold / 2
+		}
+		expr := fmt.Sprintf("scrape_duration_seconds%s > %s", selector, strconv.FormatFloat(threshold, 'f', -1, 64))
+		rules = append(rules, newBasicAlertRule(alerts.ScrapeDuration, "ScrapeDurationHigh", expr, defaultScrapeDurationFor,
+			fmt.Sprintf("the scrape of the target {{ $labels.instance }} of %s is taking longer than %ss", service, strconv.FormatFloat(threshold, 'f', -1, 64))))
+	}
+	for _, rule := range alerts.Rules {
+		generated := &AlertRule{
+			Alert:       rule.Alert,
+			Expr:        rule.Expr,
+			For:         rule.For,
+			Annotations: rule.Annotations,
+		}
+		if len(rule.Labels) > 0 {
+			generated.Labels = make(map[string]string, 0)
+			for name, value := range rule.Labels {
+				generated.Labels[sanitizeLabelName(name)] = value
+			}
+		}
+		rules = append(rules, generated)
+	}
+
+	return rules
+}
+
+// generateAlertFiles generates the rule files, a group per metrics entry named after its scrape job;
+// the entries with invalid alerts, or a group name already in use, are skipped
+func generateAlertFiles(services map[serviceKey][]*Metrics) map[string]*ruleFile {
+	files := make(map[string]*ruleFile, 0)
+	found := make(map[string]bool, 0)
+	for _, key := range sortedServiceKeys(services) {
+		for _, metric := range services[key] {
+			if metric.Alerts == nil {
+				continue
+			}
+			if problems := validateAlerts(metric); len(problems) > 0 {
+				glog.Errorf("skipping the alerts of the service: %s/%s, entry: %s, errors: %s",
+					key.namespace, key.name, metricName(metric), strings.Join(problems, ", "))
+				alertFailuresMetric.WithLabelValues(key.namespace).Inc()
+				continue
+			}
+			// step: prometheus rejects a rule file with duplicate group names
+			if found[serviceJobName(key, metric)] {
+				glog.Errorf("skipping the alerts of the service: %s/%s, entry: %s, the group: %s already exists",
+					key.namespace, key.name, metricName(metric), serviceJobName(key, metric))
+				alertFailuresMetric.WithLabelValues(key.namespace).Inc()
+				continue
+			}
+			found[serviceJobName(key, metric)] = true
+			filename := getAlertsFilename(key.namespace, key.name)
+			if _, found := files[filename]; !found {
+				files[filename] = &ruleFile{}
+			}
+			files[filename].Groups = append(files[filename].Groups, &ruleGroup{
+				Name:  serviceJobName(key, metric),
+				Rules: generateAlertRules(key, metric),
+			})
+		}
+	}
+
+	return files
+}
+
+// writeAlertFiles writes the rule files, removing any from a previous generation which are no longer
+// required; we return true if any of the files changed, requiring prometheus to be reloaded
+func (r *PrometheusK8S) writeAlertFiles(services map[serviceKey][]*Metrics) (bool, error) {
+	var err error
+	changed := false
+	files := generateAlertFiles(services)
+
+	// step: write the files in a stable order
+	filenames := make([]string, 0, len(files))
+	for filename := range files {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	// step: never write over one of the other files we generate
+	reserved := r.reservedFiles()
+	owned := make(map[string]bool, len(files))
+	for _, filename := range filenames {
+		if reserved[filename] || (splitPods() && getPodsFilenameRegex().MatchString(filename)) {
+			glog.Errorf("skipping the rule file: %s, the filename is used by the targets or templates", filename)
+			continue
+		}
+		owned[filename] = true
+		content, encodeErr := encodeAs(files[filename], getConfig().OutputFormat)
+		if encodeErr != nil {
+			return changed, fmt.Errorf("failed to encode the rule file: %s, error: %s", filename, encodeErr)
+		}
+		written, writeErr := r.getSink().write(filename, content)
+		if writeErr != nil {
+			glog.Errorf("failed to write the rule file: %s, error: %s", filename, writeErr)
+			err = writeErr
+		}
+		changed = changed || written
+	}
+
+	// step: remove the files matching the pattern for namespaces or services which no longer have
+	// alerts, including those written by a previous run; any other file in the output is left alone
+	existing, listErr := r.getSink().list()
+	if listErr != nil {
+		return changed, fmt.Errorf("unable to list the existing rule files, error: %s", listErr)
+	}
+	for _, filename := range existing {
+		if owned[filename] || reserved[filename] || !isAlertsFile(filename) ||
+			(splitPods() && getPodsFilenameRegex().MatchString(filename)) {
+			continue
+		}
+		glog.Infof("removing the rule file: %s, the namespace or service no longer has alerts", filename)
+		if removeErr := r.getSink().remove(filename); removeErr != nil {
+			glog.Errorf("failed to remove the rule file: %s, error: %s", filename, removeErr)
+			err = removeErr
+			continue
+		}
+		changed = true
+	}
+
+	return changed, err
+}
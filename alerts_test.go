@@ -0,0 +1,289 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func newTestAlertServices() map[serviceKey][]*Metrics {
+	return map[serviceKey][]*Metrics{
+		{namespace: "default", name: "web"}: {
+			{
+				Name:    "web",
+				Port:    8080,
+				Timeout: "4s",
+				Alerts: &MetricAlerts{
+					TargetDown:     &BasicAlert{Severity: "critical"},
+					NoSamples:      &BasicAlert{For: "15m"},
+					ScrapeDuration: &BasicAlert{},
+					Rules: []*AlertRule{
+						{
+							Alert:  "HighErrorRate",
+							Expr:   `sum(rate(http_errors_total{namespace="default"}[5m])) by (pod) > 1`,
+							Labels: map[string]string{"team.name": "web"},
+						},
+					},
+				},
+			},
+			{Name: "admin", Port: 9090},
+		},
+		{namespace: "platform", name: "api"}: {
+			{Port: 9000, Alerts: &MetricAlerts{TargetDown: &BasicAlert{}}},
+		},
+	}
+}
+
+func TestValidateAlertsFilePattern(t *testing.T) {
+	assert.Nil(t, validateAlertsFilePattern("alerts-{namespace}.yml"))
+	assert.Nil(t, validateAlertsFilePattern("alerts-{namespace}-{service}.yml"))
+	assert.NotNil(t, validateAlertsFilePattern("alerts-{service}.yml"))
+	assert.NotNil(t, validateAlertsFilePattern("alerts-{namespace}-{endpoint}.yml"))
+	assert.NotNil(t, validateAlertsFilePattern("rules/{namespace}.yml"))
+	assert.NotNil(t, validateAlertsFilePattern("{namespace}.yml"))
+}
+
+func TestAlertsPatternOverlaps(t *testing.T) {
+	c := &Config{NodesConfigFilename: "nodes.yml", PodsConfigFilename: "pods.yml", AlertsFilePattern: "alerts-{namespace}.yml"}
+	assert.False(t, alertsPatternOverlaps(c))
+	c.PodsFilePattern = "pods-{namespace}.yml"
+	assert.False(t, alertsPatternOverlaps(c))
+	c.AlertsFilePattern = "pods-alerts-{namespace}.yml"
+	assert.True(t, alertsPatternOverlaps(c))
+	c.PodsFilePattern, c.Shards = "", 3
+	assert.False(t, alertsPatternOverlaps(c))
+	c.AlertsFilePattern = "pods-shard-alerts-{namespace}.yml"
+	assert.True(t, alertsPatternOverlaps(c))
+	c.AlertsFilePattern = "{namespace}.yml"
+	assert.True(t, alertsPatternOverlaps(c))
+}
+
+func TestDurationSeconds(t *testing.T) {
+	seconds, err := durationSeconds("500ms")
+	assert.Nil(t, err)
+	assert.Equal(t, 0.5, seconds)
+	seconds, err = durationSeconds("2m")
+	assert.Nil(t, err)
+	assert.Equal(t, float64(120), seconds)
+	_, err = durationSeconds("2 minutes")
+	assert.NotNil(t, err)
+}
+
+func TestCheckExpression(t *testing.T) {
+	valid := []string{
+		`up == 0`,
+		`up{namespace="default",pod="web",instance=~".+:80"} == 0`,
+		`sum(rate(http_requests_total{code=~"5.."}[5m])) by (namespace, pod) > 0.1`,
+		`max_over_time(up[1h:5m]) < bool 1`,
+		`label_replace(up, "name", "$1", "pod", "(.+)-[a-z0-9]+")`,
+		`{__name__=~"up|scrape_samples_scraped", namespace!="(x"} # the targets (`,
+	}
+	for _, expression := range valid {
+		assert.Nil(t, checkExpression(expression), "expression: %s should be valid", expression)
+	}
+
+	invalid := []string{
+		``,
+		`  `,
+		`up{namespace="default"`,
+		`(up > 1`,
+		`up > 1)`,
+		`rate(up[5m)]`,
+		`up{namespace="default}`,
+		`up ==`,
+		`rate(up) > 1`,
+		`sum(up) by`,
+		`up{namespace=default}`,
+		`max_over_time(up[1x])`,
+		`unknown_function(up)`,
+	}
+	for _, expression := range invalid {
+		assert.NotNil(t, checkExpression(expression), "expression: %s should be invalid", expression)
+	}
+	assert.Contains(t, checkExpression(`rate(errors_total[5m] > 1`).Error(), "unclosed left parenthesis")
+}
+
+func TestGenerateAlertRules(t *testing.T) {
+	defer func(base string) { getConfig().ScrapeConfigBase = base }(getConfig().ScrapeConfigBase)
+	getConfig().ScrapeConfigBase = ""
+
+	key := serviceKey{namespace: "default", name: "web"}
+	rules := generateAlertRules(key, newTestAlertServices()[key][0])
+	assert.Len(t, rules, 4)
+	for _, rule := range rules {
+		assert.Nil(t, checkExpression(rule.Expr), "rule: %s has an invalid expression", rule.Alert)
+	}
+
+	assert.Equal(t, "TargetDown", rules[0].Alert)
+	assert.Equal(t, `up{namespace="default",pod="web",instance=~".+:8080"} == 0`, rules[0].Expr)
+	assert.Equal(t, defaultTargetDownFor, rules[0].For)
+	assert.Equal(t, "critical", rules[0].Labels["severity"])
+	assert.Equal(t, "15m", rules[1].For)
+	assert.Equal(t, defaultAlertSeverity, rules[1].Labels["severity"])
+	assert.Equal(t, `scrape_duration_seconds{namespace="default",pod="web",instance=~".+:8080"} > 2`, rules[2].Expr)
+	assert.Equal(t, map[string]string{"team_name": "web"}, rules[3].Labels)
+}
+
+func TestAlertSelectorServiceJobs(t *testing.T) {
+	defer func(base, jobs string) {
+		getConfig().ScrapeConfigBase, getConfig().ScrapeJobs = base, jobs
+	}(getConfig().ScrapeConfigBase, getConfig().ScrapeJobs)
+	getConfig().ScrapeConfigBase = "testdata/base.yml"
+	getConfig().ScrapeJobs = scrapeJobsPerService
+
+	selector := alertSelector(serviceKey{namespace: "default", name: "web"}, &Metrics{Port: 80})
+	assert.Equal(t, `{job="default/web/80",namespace="default",pod="web",instance=~".+:80"}`, selector)
+}
+
+func TestAlertSelectorEndpointLabel(t *testing.T) {
+	defer func(base, jobs, label string) {
+		getConfig().ScrapeConfigBase, getConfig().ScrapeJobs, getConfig().EndpointLabel = base, jobs, label
+	}(getConfig().ScrapeConfigBase, getConfig().ScrapeJobs, getConfig().EndpointLabel)
+	getConfig().ScrapeConfigBase = ""
+	getConfig().EndpointLabel = "job"
+
+	// check: the job is the name of the entry, or the port, as set on the targets
+	key := serviceKey{namespace: "default", name: "web"}
+	assert.Equal(t, `{job="http",namespace="default",pod="web",instance=~".+:80"}`, alertSelector(key, &Metrics{Name: "http", Port: 80}))
+	assert.Equal(t, `{job="80",namespace="default",pod="web",instance=~".+:80"}`, alertSelector(key, &Metrics{Port: 80}))
+
+	getConfig().ScrapeConfigBase = "testdata/base.yml"
+	for _, jobs := range []string{scrapeJobsPerService, scrapeJobsPerFile} {
+		getConfig().ScrapeJobs = jobs
+		assert.Equal(t, `{job="http",namespace="default",pod="web",instance=~".+:80"}`, alertSelector(key, &Metrics{Name: "http", Port: 80}))
+	}
+
+	// check: the selector matches the labels of the generated targets
+	ks8 := newTestPrometheusK8S(t)
+	pods := []*Pod{{ID: "web-1", Name: "web", Namespace: "default", Address: "10.0.0.1", Phase: "Running"}}
+	metric := &Metrics{Name: "http", Port: 80}
+	targets := ks8.generatePodsConfiguration(pods, map[serviceKey][]*Metrics{key: {metric}})
+	if assert.Len(t, targets, 1) {
+		assert.Equal(t, "http", targets[0].Labels["job"])
+	}
+}
+
+func TestGenerateAlertFilesInvalid(t *testing.T) {
+	defer func(pattern string) { getConfig().AlertsFilePattern = pattern }(getConfig().AlertsFilePattern)
+	getConfig().AlertsFilePattern = "alerts-{namespace}.yml"
+
+	services := newTestAlertServices()
+	services[serviceKey{namespace: "platform", name: "api"}][0].Alerts.Rules = []*AlertRule{{Alert: "Broken", Expr: "rate(up[5m]"}}
+	files := generateAlertFiles(services)
+	assert.Contains(t, files, "alerts-default.yml")
+	assert.NotContains(t, files, "alerts-platform.yml")
+}
+
+func TestGenerateAlertFilesDuplicateGroups(t *testing.T) {
+	defer func(pattern string) { getConfig().AlertsFilePattern = pattern }(getConfig().AlertsFilePattern)
+	getConfig().AlertsFilePattern = "alerts-{namespace}.yml"
+
+	key := serviceKey{namespace: "default", name: "web"}
+	services := map[serviceKey][]*Metrics{key: {
+		{Name: "http", Port: 80, Alerts: &MetricAlerts{TargetDown: &BasicAlert{}}},
+		{Name: "http", Port: 8080, Alerts: &MetricAlerts{NoSamples: &BasicAlert{}}},
+		{Name: "admin", Port: 9090, Alerts: &MetricAlerts{TargetDown: &BasicAlert{}}},
+	}}
+	files := generateAlertFiles(services)
+	if assert.Contains(t, files, "alerts-default.yml") {
+		groups := files["alerts-default.yml"].Groups
+		if assert.Len(t, groups, 2) {
+			assert.Equal(t, "default/web/http", groups[0].Name)
+			assert.Equal(t, "TargetDown", groups[0].Rules[0].Alert)
+			assert.Equal(t, "default/web/admin", groups[1].Name)
+		}
+	}
+}
+
+func TestWriteAlertFiles(t *testing.T) {
+	defer func(pattern, format string) {
+		getConfig().AlertsFilePattern, getConfig().OutputFormat = pattern, format
+	}(getConfig().AlertsFilePattern, getConfig().OutputFormat)
+	getConfig().AlertsFilePattern = "alerts-{namespace}.yml"
+	getConfig().OutputFormat = formatYAML
+
+	ks8 := newTestPrometheusK8S(t)
+	sink := ks8.sink.(*fakeSink)
+	sink.files["alerts-other.yml"] = []byte("groups: []")
+	sink.files["pods.yml"] = []byte("[]")
+	sink.files["rules.yml"] = []byte("groups: []")
+
+	// check: a stale file from a previous run is removed, other files are left alone
+	changed, err := ks8.writeAlertFiles(newTestAlertServices())
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	assert.Contains(t, sink.files, "alerts-default.yml")
+	assert.Contains(t, sink.files, "alerts-platform.yml")
+	assert.Contains(t, sink.files, "pods.yml")
+	assert.Contains(t, sink.files, "rules.yml")
+	assert.NotContains(t, sink.files, "alerts-other.yml")
+
+	rules := &ruleFile{}
+	assert.Nil(t, yaml.Unmarshal(sink.files["alerts-default.yml"], rules))
+	if assert.Len(t, rules.Groups, 1) {
+		assert.Equal(t, "default/web/web", rules.Groups[0].Name)
+		assert.Len(t, rules.Groups[0].Rules, 4)
+	}
+
+	changed, err = ks8.writeAlertFiles(newTestAlertServices())
+	assert.Nil(t, err)
+	assert.False(t, changed)
+
+	// check: the files no longer required are removed
+	services := newTestAlertServices()
+	delete(services, serviceKey{namespace: "platform", name: "api"})
+	changed, err = ks8.writeAlertFiles(services)
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	assert.NotContains(t, sink.files, "alerts-platform.yml")
+	assert.Contains(t, sink.files, "alerts-default.yml")
+	assert.Contains(t, sink.files, "rules.yml")
+}
+
+func TestWriteAlertFilesReserved(t *testing.T) {
+	defer func(pattern, nodes, format string) {
+		getConfig().AlertsFilePattern, getConfig().NodesConfigFilename, getConfig().OutputFormat = pattern, nodes, format
+	}(getConfig().AlertsFilePattern, getConfig().NodesConfigFilename, getConfig().OutputFormat)
+	getConfig().AlertsFilePattern = "alerts-{namespace}.yml"
+	getConfig().NodesConfigFilename = "alerts-platform.yml"
+	getConfig().OutputFormat = formatYAML
+
+	ks8 := newTestPrometheusK8S(t)
+	sink := ks8.sink.(*fakeSink)
+	sink.files["alerts-platform.yml"] = []byte("[]")
+
+	_, err := ks8.writeAlertFiles(newTestAlertServices())
+	assert.Nil(t, err)
+	assert.Contains(t, sink.files, "alerts-default.yml")
+	assert.Equal(t, "[]", string(sink.files["alerts-platform.yml"]))
+}
+
+func TestMergeRuleFiles(t *testing.T) {
+	content, err := mergeRuleFiles([]byte("global:\n  scrape_interval: 15s\n"), "alerts-*.yml")
+	assert.Nil(t, err)
+	assert.Equal(t, "global:\n  scrape_interval: 15s\nrule_files:\n- alerts-*.yml\n", string(content))
+
+	content, err = mergeRuleFiles([]byte("rule_files:\n- base.rules\n- alerts-*.yml\n"), "alerts-*.yml")
+	assert.Nil(t, err)
+	assert.Equal(t, "rule_files:\n- base.rules\n- alerts-*.yml\n", string(content))
+
+	_, err = mergeRuleFiles([]byte("rule_files: base.rules\n"), "alerts-*.yml")
+	assert.NotNil(t, err)
+}
@@ -19,11 +19,31 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/labels"
 )
 
+// stringList is a command line option which can be specified multiple times
+type stringList []string
+
+// String returns the options as a string
+func (r *stringList) String() string {
+	return strings.Join(*r, ",")
+}
+
+// Set adds the option to the list
+func (r *stringList) Set(value string) error {
+	*r = append(*r, value)
+	return nil
+}
+
 //
 // Config ... Configuration for the service
 type Config struct {
@@ -33,20 +53,68 @@ type Config struct {
 	Port int
 	// the kubernetes namespace to listen in
 	Namespaces string
+	// a label selector used to select the namespaces to listen in
+	NamespaceSelector string
+	// the path to a kubeconfig file
+	Kubeconfig string
+	// the context to use from within the kubeconfig
+	KubeContext string
 	// the kubernetes token file if any
 	TokenFile string
 	// the kubernetes token
 	Token string
 	// the cert used to verify to kubernetes
 	CaCertFile string
+	// the client certificate used to authenticate to kubernetes
+	ClientCertFile string
+	// the private key for the client certificate
+	ClientKeyFile string
+	// the username for basic authentication
+	Username string
+	// the password for basic authentication
+	Password string
+	// override the server name used to verify the api certificate
+	TLSServerName string
+	// the label selector used to filter the pods
+	PodLabelSelector string
+	// the field selector used to filter the pods
+	PodFieldSelector string
+	// the label selector used to filter the nodes
+	NodeLabelSelector string
+	// the field selector used to filter the nodes
+	NodeFieldSelector string
 	// the metrics annotation used
 	MetricAnnotation string
 	// the filename of the nodes yaml
 	NodesConfigFilename string
 	// the filename of the pods yaml
 	PodsConfigFilename string
+	// the pattern used to split the pods into a file per namespace or service
+	PodsFilePattern string
+	// the pattern of the rule files the alerts of the pods are written to, i.e. alerts-{namespace}.yml
+	AlertsFilePattern string
+	// the number of shards the pods targets are split into, zero disables sharding
+	Shards int
+	// the key the targets are assigned to a shard by, namespace, service or pod
+	ShardKey string
+	// the label set to the name of the metrics entry, producing a target group per entry
+	EndpointLabel string
+	// the configuration file for the service
+	ConfigFile string
 	// the directory to save the configuration
 	ConfigDirectory string
+	// the configmap to write the configuration into, i.e. namespace/name
+	ConfigMap string
+	// the format of the generated files, yaml or json
+	OutputFormat string
+	// the user templates and the files to render them to, i.e. source:filename
+	Templates stringList
+	// the base prometheus configuration, enables rendering the prometheus configuration
+	ScrapeConfigBase string
+	// the filename of the rendered prometheus configuration
+	ScrapeConfigFilename string
+	// generate a scrape job per file or per service
+	ScrapeJobs string
 	// the refresh interval
 	RefreshInterval int
 	// the api version
@@ -61,43 +129,331 @@ type Config struct {
 	WithPods bool
 	// a dry run - i.e. only display to screen
 	DryRun bool
-	// Insure https
+	// compare the generated files with those in the directory rather than writing them
+	Diff bool
+	// the interface and port to serve the http endpoints on
+	ListenAddress string
+	// serve the debug endpoints on the listen address
+	EnableDebug bool
+	// the url used to reload prometheus
+	ReloadURL string
+	// the pid file of prometheus, used to send a SIGHUP
+	ReloadPidFile string
+	// the name of the prometheus process, used to send a SIGHUP
+	ReloadProcess string
+	// skip the verification of the api certificate
 	HTTPInsecure bool
+	// the lock used for leader election, i.e. configmaps/namespace/name
+	LeaderElect string
+	// the identity of this replica in the leader election
+	LeaderIdentity string
+	// the duration of the leader lease in seconds
+	LeaderLease int
+	// the options given explicitly, on the command line or in the configuration file
+	explicit map[string]bool
 }
 
 var (
-	config Config
+	// the current configuration, published atomically so a reload never races with the readers
+	currentConfig atomic.Value
+	// the command line arguments, parsed again when the configuration is reloaded
+	commandLineArgs []string
 )
 
 func init() {
-	flag.StringVar(&config.Host, "api", getEnvString("KUBERNETES_SERVICE_HOST", "127.0.0.1"), "the host / ip address the kubectl proxy is running")
-	flag.StringVar(&config.NodesConfigFilename, "node-file", "nodes.yml", "the filename of the nodes yaml file")
-	flag.StringVar(&config.PodsConfigFilename, "pod-file", "pods.yml", "the filename of of the pods yaml")
-	flag.StringVar(&config.APIVersion, "api-version", "v1", "the protocol to use when connecting to the api")
-	flag.StringVar(&config.APIProtocol, "api-protocol", "http", "the kubernetes api version to use")
-	flag.StringVar(&config.ConfigDirectory, "config", ".", "the directory save the genrated files into")
-	flag.StringVar(&config.MetricAnnotation, "metrics", "metrics", "the tag used in the pods annotations")
-	flag.StringVar(&config.TokenFile, "bearer-token-file", "", "The file containing the bearer token")
-	flag.StringVar(&config.Token, "bearer-token", "", "a kubernetes token to authenticate to the api")
-	flag.StringVar(&config.CaCertFile, "ca-cert-file", "", "The file containing the CA certificate")
-	flag.StringVar(&config.Namespaces, "namespace", getEnvString("KUBERNETES_NAMESPACE", api.NamespaceAll), "the kubernetes namespace to watched, defaults to all")
-	flag.BoolVar(&config.HTTPInsecure, "insecure", true, "If true, the server's certificate will not be checked for validity. This will make your HTTPS connections insecure")
-	flag.IntVar(&config.Port, "port", getEnvInt("KUBERNETES_SERVICE_PORT", 8001), "the port the api proxy is running on")
-	flag.IntVar(&config.NodePort, "node-port", 4194, "if with-nodes enabled, the port specified is used")
-	flag.IntVar(&config.RefreshInterval, "interval", 300, "the refresh interval in seconds that we perform a forced refresh")
-	flag.BoolVar(&config.WithNodes, "nodes", false, "generate the metric endpoints for all kubernetes nodes in the cluster")
-	flag.BoolVar(&config.WithPods, "pods", true, "generate the metric endpoints for pods which container prometheus endpoints")
-	flag.BoolVar(&config.DryRun, "dry-run", false, "perform a dry run, display output to screen only")
+	c := new(Config)
+	registerFlags(flag.CommandLine, c)
+	setConfig(c)
+}
+
+// getConfig returns the current configuration, which must be treated as read only
+func getConfig() *Config {
+	return currentConfig.Load().(*Config)
+}
+
+// setConfig publishes the configuration
+func setConfig(c *Config) {
+	currentConfig.Store(c)
+}
+
+// registerFlags registers the command line options of the configuration
+func registerFlags(flags *flag.FlagSet, c *Config) {
+	hostname, _ := os.Hostname()
+	flags.StringVar(&c.Host, "api", getEnvString("KUBERNETES_SERVICE_HOST", "127.0.0.1"), "the host / ip address the kubectl proxy is running")
+	flags.StringVar(&c.NodesConfigFilename, "node-file", "nodes.yml", "the filename of the nodes yaml file")
+	flags.StringVar(&c.PodsConfigFilename, "pod-file", "pods.yml", "the filename of of the pods yaml")
+	flags.StringVar(&c.PodsFilePattern, "pod-file-pattern", "", "split the pods into a file per namespace or service, i.e. pods-{namespace}.yml, pods-{namespace}-{service}.yml or pods-{endpoint}.yml")
+	flags.StringVar(&c.AlertsFilePattern, "alerts-file-pattern", "", "write the alerts of the metrics annotations into rule files per namespace or service, i.e. alerts-{namespace}.yml or alerts-{namespace}-{service}.yml")
+	flags.IntVar(&c.Shards, "shards", 0, "split the pods targets into this number of shards, written to pods-shard-<index>.yml, zero disables")
+	flags.StringVar(&c.ShardKey, "shard-key", shardKeyService, "the key used to assign the targets to the shards, namespace, service or pod")
+	flags.StringVar(&c.EndpointLabel, "endpoint-label", "", "produce a target group per entry of the metrics annotation, with this label set to the name of the entry, i.e. job")
+	flags.StringVar(&c.APIVersion, "api-version", "v1", "the protocol to use when connecting to the api")
+	flags.StringVar(&c.APIProtocol, "api-protocol", "http", "the kubernetes api version to use")
+	flags.StringVar(&c.ConfigFile, "config-file", "", "a yaml or json configuration file for the service, the command line options and environment variables take precedence")
+	flags.StringVar(&c.ConfigDirectory, "config", ".", "the directory save the genrated files into")
+	flags.StringVar(&c.ConfigMap, "configmap", "", "write the generated files into the keys of a configmap rather than the directory, i.e. namespace/name")
+	flags.StringVar(&c.OutputFormat, "format", formatYAML, "the format of the generated files, yaml or json, the .yml and .json extensions of the files are swapped to match")
+	flags.Var(&c.Templates, "template", "a template and the file to render it to, i.e. /etc/templates/upstreams.tmpl:upstreams.conf, can be specified multiple times")
+	flags.StringVar(&c.ScrapeConfigBase, "scrape-config-base", "", "a base prometheus configuration, if set a full prometheus configuration is rendered with the scrape jobs added")
+	flags.StringVar(&c.ScrapeConfigFilename, "scrape-config-file", "prometheus.yml", "the filename of the rendered prometheus configuration")
+	flags.StringVar(&c.ScrapeJobs, "scrape-jobs", scrapeJobsPerFile, "generate a scrape job per output file (file) or per annotated service (service)")
+	flags.StringVar(&c.MetricAnnotation, "metrics", "metrics", "the tag used in the pods annotations")
+	flags.StringVar(&c.Kubeconfig, "kubeconfig", getEnvString("KUBECONFIG", ""), "the path to a kubeconfig file used to connect to the api")
+	flags.StringVar(&c.KubeContext, "context", "", "the context within the kubeconfig to use, defaults to the current context")
+	flags.StringVar(&c.TokenFile, "bearer-token-file", "", "The file containing the bearer token")
+	flags.StringVar(&c.Token, "bearer-token", "", "a kubernetes token to authenticate to the api")
+	flags.StringVar(&c.CaCertFile, "ca-cert-file", "", "The file containing the CA certificate")
+	flags.StringVar(&c.NamespaceSelector, "namespace-selector", "", "a label selector used to select the namespaces to watch, i.e. monitoring=enabled")
+	flags.StringVar(&c.ClientCertFile, "client-cert-file", "", "the file containing the client certificate used to authenticate to the api")
+	flags.StringVar(&c.ClientKeyFile, "client-key-file", "", "the file containing the private key for the client certificate")
+	flags.StringVar(&c.Username, "username", getEnvString("KUBERNETES_USERNAME", ""), "the username used for basic authentication to the api")
+	flags.StringVar(&c.Password, "password", getEnvString("KUBERNETES_PASSWORD", ""), "the password used for basic authentication to the api")
+	flags.StringVar(&c.TLSServerName, "tls-server-name", "", "override the server name used to verify the api certificate")
+	flags.StringVar(&c.Namespaces, "namespace", getEnvString("KUBERNETES_NAMESPACE", api.NamespaceAll), "the kubernetes namespace to watched, defaults to all")
+	flags.StringVar(&c.PodLabelSelector, "pod-selector", "", "a label selector used to filter the pods, i.e. monitoring=enabled")
+	flags.StringVar(&c.PodFieldSelector, "pod-field-selector", "", "a field selector used to filter the pods, i.e. spec.nodeName=$NODE_NAME")
+	flags.StringVar(&c.NodeLabelSelector, "node-selector", "", "a label selector used to filter the nodes")
+	flags.StringVar(&c.NodeFieldSelector, "node-field-selector", "", "a field selector used to filter the nodes, i.e. metadata.name=$NODE_NAME")
+	flags.BoolVar(&c.HTTPInsecure, "insecure", false, "If true, the server's certificate will not be checked for validity. This will make your HTTPS connections insecure")
+	flags.IntVar(&c.Port, "port", getEnvInt("KUBERNETES_SERVICE_PORT", 8001), "the port the api proxy is running on")
+	flags.IntVar(&c.NodePort, "node-port", 4194, "if with-nodes enabled, the port specified is used")
+	flags.IntVar(&c.RefreshInterval, "interval", 300, "the refresh interval in seconds that we perform a forced refresh")
+	flags.BoolVar(&c.WithNodes, "nodes", false, "generate the metric endpoints for all kubernetes nodes in the cluster")
+	flags.BoolVar(&c.WithPods, "pods", true, "generate the metric endpoints for pods which container prometheus endpoints")
+	flags.BoolVar(&c.DryRun, "dry-run", false, "perform a dry run, display output to screen only")
+	flags.BoolVar(&c.Diff, "diff", false, "display the targets which would be added or removed from the files in the config directory and exit, the exit code is 1 if anything would change")
+	flags.StringVar(&c.ReloadURL, "reload-url", "", "the url used to reload prometheus on configuration changes, i.e. http://127.0.0.1:9090/-/reload")
+	flags.StringVar(&c.ReloadPidFile, "reload-pid-file", "", "the pid file of prometheus, a SIGHUP is sent on configuration changes")
+	flags.StringVar(&c.ReloadProcess, "reload-process", "", "the name of the prometheus process within a shared process namespace, a SIGHUP is sent on configuration changes")
+	flags.StringVar(&c.LeaderElect, "leader-elect", "", "enable leader election using the lock, i.e. configmaps/namespace/name or endpoints/namespace/name, only the leader writes the -configmap")
+	flags.StringVar(&c.LeaderIdentity, "leader-identity", getEnvString("POD_NAME", hostname), "the identity of this replica in the leader election, defaults to the pod name or hostname")
+	flags.IntVar(&c.LeaderLease, "leader-lease", 15, "the duration in seconds of the leader lease, a follower takes over once it has expired")
+	flags.StringVar(&c.ListenAddress, "listen", "", "the interface and port to serve the metrics, health checks and discovery on, i.e. :8080, disabled by default")
+	flags.BoolVar(&c.EnableDebug, "enable-debug", false, "serve the debug endpoints, i.e. /debug/explain, on the listen address")
 }
 
 func parseConfig() error {
-	// step: parse the command line arguments
-	flag.Parse()
-	// step: validate we have everything we need to proceed
-	location := fmt.Sprintf("%s://%s:%d", config.APIProtocol, config.Host, config.Port)
+	return parseConfigArgs(os.Args[1:])
+}
+
+// parseConfigArgs parses the command line arguments, builds the configuration and publishes it
+func parseConfigArgs(args []string) error {
+	// step: parse the command line arguments, the logging options and arguments are taken from here
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return err
+	}
+	c, err := loadConfig(args)
+	if err != nil {
+		return err
+	}
+	commandLineArgs = args
+	setConfig(c)
+
+	return nil
+}
+
+// loadConfig builds a new configuration from the command line arguments and the configuration file,
+// the command line options take precedence; the current configuration is left untouched
+func loadConfig(args []string) (*Config, error) {
+	c := new(Config)
+	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	registerFlags(flags, c)
+	// step: the other options, i.e. the logging, are only applied by the command line
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		if flags.Lookup(f.Name) == nil {
+			flags.Var(&ignoredFlag{Value: f.Value}, f.Name, f.Usage)
+		}
+	})
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// step: load the configuration file if any and validate the result
+	var errs configErrors
+	if c.ConfigFile != "" {
+		errs = append(errs, loadConfigFile(flags, explicitFlags(flags), c.ConfigFile)...)
+	}
+	errs = append(errs, validateConfig(c)...)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	c.explicit = explicitFlags(flags)
+
+	return c, nil
+}
+
+// isSet checks if the option was given explicitly, either on the command line or in the configuration
+// file, rather than left to the default
+func (c *Config) isSet(name string) bool {
+	return c.explicit[name]
+}
+
+// ignoredFlag stands in for an option outside of the configuration, the value is never changed
+type ignoredFlag struct {
+	flag.Value
+}
+
+// Set ignores the value
+func (r *ignoredFlag) Set(string) error {
+	return nil
+}
+
+// IsBoolFlag indicates if the option can be given without a value
+func (r *ignoredFlag) IsBoolFlag() bool {
+	value, ok := r.Value.(interface {
+		IsBoolFlag() bool
+	})
+
+	return ok && value.IsBoolFlag()
+}
+
+// validateConfig checks the configuration, returning all of the errors found
+func validateConfig(c *Config) []error {
+	var errs []error
+	location := fmt.Sprintf("%s://%s:%d", c.APIProtocol, c.Host, c.Port)
 	// check: ensure the location is valid
 	if _, err := url.Parse(location); err != nil {
-		return fmt.Errorf("invalid URL specified, please check the url and port, error: %s", err)
+		errs = append(errs, fmt.Errorf("invalid URL specified, please check the url and port, error: %s", err))
 	}
-	return nil
+	// check: the client certificate requires a key
+	if (c.ClientCertFile == "") != (c.ClientKeyFile == "") {
+		errs = append(errs, fmt.Errorf("you must specify both the client certificate and key"))
+	}
+	// check: the ca and insecure options are mutually exclusive
+	if c.HTTPInsecure && c.CaCertFile != "" {
+		errs = append(errs, fmt.Errorf("you cannot specify a ca certificate and insecure together"))
+	}
+	// check: the refresh interval is valid
+	if c.RefreshInterval <= 0 {
+		errs = append(errs, fmt.Errorf("the refresh interval must be greater than zero"))
+	}
+	// check: ensure the output format is valid
+	if c.OutputFormat != formatYAML && c.OutputFormat != formatJSON {
+		errs = append(errs, fmt.Errorf("invalid output format: %s, must be either %s or %s", c.OutputFormat, formatYAML, formatJSON))
+	}
+	// check: ensure the templates are valid
+	for _, option := range c.Templates {
+		source, _, err := parseTemplateOption(option)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !fileExists(source) {
+			errs = append(errs, fmt.Errorf("the template: %s does not exist", source))
+		}
+	}
+	// check: ensure the prometheus configuration options are valid
+	if c.ScrapeConfigBase != "" {
+		if !fileExists(c.ScrapeConfigBase) {
+			errs = append(errs, fmt.Errorf("the base prometheus configuration: %s does not exist", c.ScrapeConfigBase))
+		}
+		if c.ScrapeJobs != scrapeJobsPerFile && c.ScrapeJobs != scrapeJobsPerService {
+			errs = append(errs, fmt.Errorf("invalid scrape jobs: %s, must be either %s or %s", c.ScrapeJobs, scrapeJobsPerFile, scrapeJobsPerService))
+		}
+	}
+	// check: the pods file pattern is valid
+	if c.PodsFilePattern != "" {
+		if err := validatePodsFilePattern(c.PodsFilePattern); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	// check: the alerts file pattern is valid and does not overlap with the pods files
+	if c.AlertsFilePattern != "" {
+		if err := validateAlertsFilePattern(c.AlertsFilePattern); err != nil {
+			errs = append(errs, err)
+		} else if alertsPatternOverlaps(c) {
+			errs = append(errs, fmt.Errorf("the alerts file pattern: %s overlaps with the target files", c.AlertsFilePattern))
+		}
+	}
+	// check: the sharding is valid
+	if c.Shards < 0 {
+		errs = append(errs, fmt.Errorf("the number of shards cannot be negative"))
+	}
+	if c.Shards > 0 {
+		if c.ShardKey != shardKeyNamespace && c.ShardKey != shardKeyService && c.ShardKey != shardKeyPod {
+			errs = append(errs, fmt.Errorf("invalid shard key: %s, must be %s, %s or %s", c.ShardKey, shardKeyNamespace, shardKeyService, shardKeyPod))
+		}
+		if c.PodsFilePattern != "" {
+			errs = append(errs, fmt.Errorf("the shards cannot be used with the pods file pattern"))
+		}
+		if c.ScrapeConfigBase != "" {
+			errs = append(errs, fmt.Errorf("the prometheus configuration cannot be rendered with shards, each prometheus should read the file of its shard"))
+		}
+	}
+	// check: the endpoint label is valid
+	if c.EndpointLabel != "" {
+		if sanitizeLabelName(c.EndpointLabel) != c.EndpointLabel {
+			errs = append(errs, fmt.Errorf("the endpoint label: %s is not a valid label name", c.EndpointLabel))
+		}
+		if c.EndpointLabel == "namespace" || c.EndpointLabel == "pod" {
+			errs = append(errs, fmt.Errorf("the endpoint label cannot be namespace or pod, these are already used"))
+		}
+	}
+	// check: the diff is against the files in the directory
+	if c.Diff && (c.DryRun || c.ConfigMap != "") {
+		errs = append(errs, fmt.Errorf("the diff option cannot be used with the dry run or configmap options"))
+	}
+	// check: the configmap is valid
+	if c.ConfigMap != "" {
+		if _, _, err := parseConfigMapOption(c.ConfigMap); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	// check: the leader election is valid
+	if c.LeaderElect != "" {
+		kind, namespace, name, err := parseLeaderLockOption(c.LeaderElect)
+		if err != nil {
+			errs = append(errs, err)
+		} else if kind == "configmaps" && c.ConfigMap == namespace+"/"+name {
+			errs = append(errs, fmt.Errorf("the leader election lock cannot be the configmap the outputs are written to"))
+		}
+		if c.LeaderIdentity == "" {
+			errs = append(errs, fmt.Errorf("the leader identity cannot be empty"))
+		}
+		if time.Duration(c.LeaderLease)*time.Second <= 2*leaderRetryPeriod {
+			errs = append(errs, fmt.Errorf("the leader lease must be greater than %d seconds", int(2*leaderRetryPeriod/time.Second)))
+		}
+		if c.DryRun || c.Diff {
+			errs = append(errs, fmt.Errorf("the leader election cannot be used with the dry run or diff options"))
+		}
+	}
+	// check: only one method of reloading can be used
+	reloaders := 0
+	for _, option := range []string{c.ReloadURL, c.ReloadPidFile, c.ReloadProcess} {
+		if option != "" {
+			reloaders++
+		}
+	}
+	if reloaders > 1 {
+		errs = append(errs, fmt.Errorf("you can only specify one of the reload url, pid file or process"))
+	}
+	if c.ReloadURL != "" {
+		if _, err := url.Parse(c.ReloadURL); err != nil {
+			errs = append(errs, fmt.Errorf("invalid reload url: %s, error: %s", c.ReloadURL, err))
+		}
+	}
+	// check: ensure the selectors are valid
+	if _, _, err := parseSelectors(c.PodLabelSelector, c.PodFieldSelector); err != nil {
+		errs = append(errs, err)
+	}
+	if _, _, err := parseSelectors(c.NodeLabelSelector, c.NodeFieldSelector); err != nil {
+		errs = append(errs, err)
+	}
+	// check: the namespaces are either a static list or selected
+	if c.NamespaceSelector != "" {
+		if c.Namespaces != "" {
+			errs = append(errs, fmt.Errorf("you cannot specify both a list of namespaces and a namespace selector"))
+		}
+		if _, err := labels.Parse(c.NamespaceSelector); err != nil {
+			errs = append(errs, fmt.Errorf("invalid namespace selector: %s, error: %s", c.NamespaceSelector, err))
+		}
+	}
+	// check: ensure the kubeconfig exists
+	if c.Kubeconfig != "" {
+		if _, err := os.Stat(c.Kubeconfig); os.IsNotExist(err) {
+			errs = append(errs, fmt.Errorf("the kubeconfig file: %s does not exist", c.Kubeconfig))
+		}
+	}
+	return errs
 }
@@ -0,0 +1,273 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// the version of the configuration file schema
+	configFileVersion = "v1"
+)
+
+// configErrors is a list of the errors found in the configuration
+type configErrors []error
+
+// Error returns all of the errors, one per line
+func (r configErrors) Error() string {
+	var list []string
+	for _, err := range r {
+		list = append(list, err.Error())
+	}
+
+	return strings.Join(list, "\n")
+}
+
+// configFileFields maps the fields of the configuration file onto the command line options
+var configFileFields = map[string]string{
+	"kubernetes.api":                "api",
+	"kubernetes.port":               "port",
+	"kubernetes.protocol":           "api-protocol",
+	"kubernetes.version":            "api-version",
+	"kubernetes.kubeconfig":         "kubeconfig",
+	"kubernetes.context":            "context",
+	"kubernetes.token":              "bearer-token",
+	"kubernetes.token_file":         "bearer-token-file",
+	"kubernetes.ca_file":            "ca-cert-file",
+	"kubernetes.client_cert_file":   "client-cert-file",
+	"kubernetes.client_key_file":    "client-key-file",
+	"kubernetes.username":           "username",
+	"kubernetes.password":           "password",
+	"kubernetes.insecure":           "insecure",
+	"kubernetes.tls_server_name":    "tls-server-name",
+	"discovery.annotation":          "metrics",
+	"discovery.interval":            "interval",
+	"discovery.namespaces":          "namespace",
+	"discovery.namespace_selector":  "namespace-selector",
+	"discovery.pods":                "pods",
+	"discovery.pod_selector":        "pod-selector",
+	"discovery.pod_field_selector":  "pod-field-selector",
+	"discovery.nodes":               "nodes",
+	"discovery.node_port":           "node-port",
+	"discovery.node_selector":       "node-selector",
+	"discovery.node_field_selector": "node-field-selector",
+	"discovery.endpoint_label":      "endpoint-label",
+	"output.directory":              "config",
+	"output.format":                 "format",
+	"output.nodes_file":             "node-file",
+	"output.pods_file":              "pod-file",
+	"output.pods_file_pattern":      "pod-file-pattern",
+	"output.alerts_file_pattern":    "alerts-file-pattern",
+	"output.shards":                 "shards",
+	"output.shard_key":              "shard-key",
+	"output.configmap":              "configmap",
+	"output.templates":              "template",
+	"prometheus.base":               "scrape-config-base",
+	"prometheus.file":               "scrape-config-file",
+	"prometheus.jobs":               "scrape-jobs",
+	"prometheus.reload.url":         "reload-url",
+	"prometheus.reload.pid_file":    "reload-pid-file",
+	"prometheus.reload.process":     "reload-process",
+	"server.listen":                 "listen",
+	"server.debug":                  "enable-debug",
+	"leader_election.lock":          "leader-elect",
+	"leader_election.identity":      "leader-identity",
+	"leader_election.lease":         "leader-lease",
+}
+
+// configFileEnvironment is the environment variables which take precedence over the configuration file
+var configFileEnvironment = map[string]string{
+	"api":             "KUBERNETES_SERVICE_HOST",
+	"port":            "KUBERNETES_SERVICE_PORT",
+	"kubeconfig":      "KUBECONFIG",
+	"username":        "KUBERNETES_USERNAME",
+	"password":        "KUBERNETES_PASSWORD",
+	"namespace":       "KUBERNETES_NAMESPACE",
+	"leader-identity": "POD_NAME",
+}
+
+// loadConfigFile reads the configuration file and applies it to the options
+func loadConfigFile(flags *flag.FlagSet, explicit map[string]bool, filename string) []error {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return []error{fmt.Errorf("unable to read the configuration file: %s, error: %s", filename, err)}
+	}
+
+	return applyConfigFile(flags, explicit, content)
+}
+
+// explicitFlags returns the options which have been set
+func explicitFlags(flags *flag.FlagSet) map[string]bool {
+	explicit := make(map[string]bool, 0)
+	flags.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	return explicit
+}
+
+// applyConfigFile decodes the configuration file, yaml or json, and sets any of the options which
+// have not been given explicitly or by the environment; all the errors are returned
+func applyConfigFile(flags *flag.FlagSet, explicit map[string]bool, content []byte) []error {
+	var document map[string]interface{}
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return []error{fmt.Errorf("unable to decode the configuration file, error: %s", err)}
+	}
+
+	var errs []error
+	// check: the version of the schema is supported
+	switch version := document["version"]; version {
+	case nil:
+		errs = append(errs, fmt.Errorf("version: is required, the current version is %s", configFileVersion))
+	case configFileVersion:
+	default:
+		errs = append(errs, fmt.Errorf("version: unsupported version: %v, the current version is %s", version, configFileVersion))
+	}
+	delete(document, "version")
+
+	for _, field := range flattenConfigFile("", document, &errs) {
+		name := configFileFields[field.path]
+		if explicit[name] {
+			continue
+		}
+		if env, found := configFileEnvironment[name]; found && os.Getenv(env) != "" {
+			continue
+		}
+		for _, value := range field.values {
+			if err := flags.Set(name, value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid value: %s, error: %s", field.path, value, err))
+			}
+		}
+	}
+
+	return errs
+}
+
+// configFileField is the path of a field and the values to set the option to
+type configFileField struct {
+	// the path to the field, i.e. discovery.namespaces
+	path string
+	// the values of the field
+	values []string
+}
+
+// flattenConfigFile walks the document converting the fields into the option values, any unknown
+// fields or invalid values are added to the errors
+func flattenConfigFile(prefix string, document map[string]interface{}, errs *[]error) []*configFileField {
+	keys := make([]string, 0, len(document))
+	for key := range document {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var list []*configFileField
+	for _, key := range keys {
+		path := prefix + key
+		value := document[key]
+		if _, found := configFileFields[path]; !found {
+			section, isSection := toStringMap(value)
+			if !isSection || !isConfigFileSection(path) {
+				*errs = append(*errs, fmt.Errorf("%s: unknown field", path))
+				continue
+			}
+			list = append(list, flattenConfigFile(path+".", section, errs)...)
+			continue
+		}
+
+		values, err := configFileValues(path, value)
+		if err != nil {
+			*errs = append(*errs, fmt.Errorf("%s: %s", path, err))
+			continue
+		}
+		list = append(list, &configFileField{path: path, values: values})
+	}
+
+	return list
+}
+
+// configFileValues converts the value of a field into the values of the option
+func configFileValues(path string, value interface{}) ([]string, error) {
+	items, isList := value.([]interface{})
+	if !isList {
+		if _, isMap := toStringMap(value); isMap || value == nil {
+			return nil, fmt.Errorf("expected a value")
+		}
+		return []string{fmt.Sprintf("%v", value)}, nil
+	}
+
+	var values []string
+	for _, item := range items {
+		// step: the templates can be given as a source and filename
+		if template, isMap := toStringMap(item); isMap && path == "output.templates" {
+			source, _ := template["source"].(string)
+			filename, _ := template["filename"].(string)
+			if source == "" || filename == "" || len(template) != 2 {
+				return nil, fmt.Errorf("the templates must have a source and filename")
+			}
+			values = append(values, source+":"+filename)
+			continue
+		}
+		if _, isMap := toStringMap(item); isMap {
+			return nil, fmt.Errorf("expected a list of values")
+		}
+		values = append(values, fmt.Sprintf("%v", item))
+	}
+
+	switch path {
+	case "output.templates":
+		return values, nil
+	case "discovery.namespaces":
+		return []string{strings.Join(values, ",")}, nil
+	default:
+		return nil, fmt.Errorf("expected a single value, not a list")
+	}
+}
+
+// isConfigFileSection checks if the path is a section of the configuration file
+func isConfigFileSection(path string) bool {
+	for field := range configFileFields {
+		if strings.HasPrefix(field, path+".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// toStringMap converts a decoded yaml map into a map of strings
+func toStringMap(value interface{}) (map[string]interface{}, bool) {
+	switch x := value.(type) {
+	case map[string]interface{}:
+		return x, true
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(x))
+		for k, v := range x {
+			converted[fmt.Sprintf("%v", k)] = v
+		}
+		return converted, true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testConfigFlags is the subset of the options used to test the configuration file
+type testConfigFlags struct {
+	host       string
+	port       int
+	namespaces string
+	nodes      bool
+	templates  stringList
+}
+
+func newTestConfigFlags() (*flag.FlagSet, *testConfigFlags) {
+	options := new(testConfigFlags)
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.StringVar(&options.host, "api", "127.0.0.1", "")
+	flags.IntVar(&options.port, "port", 8001, "")
+	flags.StringVar(&options.namespaces, "namespace", "", "")
+	flags.BoolVar(&options.nodes, "nodes", false, "")
+	flags.Var(&options.templates, "template", "")
+
+	return flags, options
+}
+
+func TestApplyConfigFile(t *testing.T) {
+	flags, options := newTestConfigFlags()
+	errs := applyConfigFile(flags, explicitFlags(flags), []byte(`
+version: v1
+kubernetes:
+  api: 10.0.0.1
+  port: 6443
+discovery:
+  namespaces: [default, platform]
+  nodes: true
+output:
+  templates:
+  - source: a.tmpl
+    filename: a.conf
+  - b.tmpl:b.conf
+`))
+	assert.Empty(t, errs)
+	assert.Equal(t, "10.0.0.1", options.host)
+	assert.Equal(t, 6443, options.port)
+	assert.Equal(t, "default,platform", options.namespaces)
+	assert.True(t, options.nodes)
+	assert.Equal(t, stringList{"a.tmpl:a.conf", "b.tmpl:b.conf"}, options.templates)
+}
+
+func TestApplyConfigFileJSON(t *testing.T) {
+	flags, options := newTestConfigFlags()
+	errs := applyConfigFile(flags, explicitFlags(flags), []byte(`{"version": "v1", "discovery": {"namespaces": "default"}}`))
+	assert.Empty(t, errs)
+	assert.Equal(t, "default", options.namespaces)
+}
+
+func TestApplyConfigFilePrecedence(t *testing.T) {
+	flags, options := newTestConfigFlags()
+	assert.Nil(t, flags.Parse([]string{"-port=9000"}))
+
+	defer os.Setenv("KUBERNETES_SERVICE_HOST", os.Getenv("KUBERNETES_SERVICE_HOST"))
+	os.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.2")
+
+	errs := applyConfigFile(flags, explicitFlags(flags), []byte("version: v1\nkubernetes:\n  api: 10.0.0.1\n  port: 6443\ndiscovery:\n  nodes: true\n"))
+	assert.Empty(t, errs)
+	assert.Equal(t, "127.0.0.1", options.host)
+	assert.Equal(t, 9000, options.port)
+	assert.True(t, options.nodes)
+}
+
+func TestApplyConfigFileErrors(t *testing.T) {
+	flags, _ := newTestConfigFlags()
+	errs := applyConfigFile(flags, explicitFlags(flags), []byte(`
+version: v2
+kubernetes:
+  api: [a, b]
+  port: not_a_port
+  unknown: true
+discovery: yes
+outputs:
+  directory: /tmp
+`))
+	var list []string
+	for _, err := range errs {
+		list = append(list, err.Error())
+	}
+	assert.Equal(t, []string{
+		"version: unsupported version: v2, the current version is v1",
+		"discovery: unknown field",
+		"kubernetes.api: expected a single value, not a list",
+		"kubernetes.unknown: unknown field",
+		"outputs: unknown field",
+		"kubernetes.port: invalid value: not_a_port, error: parse error",
+	}, list)
+
+	errs = applyConfigFile(flags, explicitFlags(flags), []byte("kubernetes: {}\n"))
+	assert.Len(t, errs, 1)
+	errs = applyConfigFile(flags, explicitFlags(flags), []byte("not yaml: ["))
+	assert.Len(t, errs, 1)
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	flags, options := newTestConfigFlags()
+	flags.String("api-protocol", "http", "")
+	flags.String("format", "yaml", "")
+	flags.String("config", ".", "")
+	flags.Int("node-port", 4194, "")
+	flags.String("reload-url", "", "")
+	assert.Empty(t, loadConfigFile(flags, explicitFlags(flags), "testdata/config.yml"))
+	assert.Equal(t, 6443, options.port)
+	assert.Equal(t, "json", flags.Lookup("format").Value.String())
+
+	assert.Len(t, loadConfigFile(flags, explicitFlags(flags), "testdata/does_not_exist.yml"), 1)
+}
+
+func TestConfigErrors(t *testing.T) {
+	err := configErrors{os.ErrNotExist, os.ErrExist}
+	assert.Equal(t, "file does not exist\nfile already exists", err.Error())
+}
+
+func TestValidateConfigReportsAllErrors(t *testing.T) {
+	c := *getConfig()
+	c.OutputFormat = "xml"
+	c.ClientCertFile = "client.pem"
+	c.Templates = stringList{"missing.tmpl:missing.conf", "invalid"}
+
+	errs := validateConfig(&c)
+	assert.Len(t, errs, 4)
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/kubernetes/pkg/api/errors"
+)
+
+// ConfigMap retrieves the configmap from the namespace, we return nil if it does not exist; the
+// version of the client we are using predates the configmap resource, so we use the rest client
+func (r *kubeAPIImpl) ConfigMap(namespace, name string) (*ConfigMap, error) {
+	content, err := r.client.Get().Namespace(namespace).Resource("configmaps").Name(name).Do().Raw()
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to retrieve the configmap: %s/%s, error: %s", namespace, name, err)
+	}
+
+	cm, err := decodeConfigMap(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the configmap: %s/%s, error: %s", namespace, name, err)
+	}
+
+	return cm, nil
+}
+
+// SaveConfigMap creates the configmap, or updates it if it has a resource version
+func (r *kubeAPIImpl) SaveConfigMap(cm *ConfigMap) error {
+	content, err := encodeConfigMap(cm)
+	if err != nil {
+		return err
+	}
+
+	if cm.ResourceVersion == "" {
+		err = r.client.Post().Namespace(cm.Namespace).Resource("configmaps").
+			SetHeader("Content-Type", "application/json").Body(content).Do().Error()
+	} else {
+		err = r.client.Put().Namespace(cm.Namespace).Resource("configmaps").Name(cm.Name).
+			SetHeader("Content-Type", "application/json").Body(content).Do().Error()
+	}
+
+	return err
+}
+
+// decodeConfigMap decodes the configmap, keeping the decoded object so an update does not lose
+// the labels, annotations, owner references or any of the other fields
+func decodeConfigMap(content []byte) (*ConfigMap, error) {
+	var object map[string]interface{}
+	if err := json.Unmarshal(content, &object); err != nil {
+		return nil, err
+	}
+
+	cm := &ConfigMap{
+		Data:   make(map[string]string, 0),
+		object: object,
+	}
+	if metadata, found := object["metadata"].(map[string]interface{}); found {
+		cm.Name, _ = metadata["name"].(string)
+		cm.Namespace, _ = metadata["namespace"].(string)
+		cm.ResourceVersion, _ = metadata["resourceVersion"].(string)
+	}
+	if data, found := object["data"].(map[string]interface{}); found {
+		for key, value := range data {
+			if text, found := value.(string); found {
+				cm.Data[key] = text
+			}
+		}
+	}
+
+	return cm, nil
+}
+
+// encodeConfigMap encodes the configmap, only the data and the fields identifying the configmap are
+// replaced within the object it was decoded from
+func encodeConfigMap(cm *ConfigMap) ([]byte, error) {
+	object := cm.object
+	if object == nil {
+		object = map[string]interface{}{
+			"kind":       "ConfigMap",
+			"apiVersion": "v1",
+		}
+	}
+	metadata, found := object["metadata"].(map[string]interface{})
+	if !found {
+		metadata = make(map[string]interface{}, 0)
+	}
+	metadata["name"] = cm.Name
+	metadata["namespace"] = cm.Namespace
+	if cm.ResourceVersion != "" {
+		metadata["resourceVersion"] = cm.ResourceVersion
+	}
+	object["metadata"] = metadata
+	object["data"] = cm.Data
+
+	return json.Marshal(object)
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeConfigMap(t *testing.T) {
+	cm, err := decodeConfigMap([]byte(`{"kind":"ConfigMap","apiVersion":"v1","metadata":{"name":"prometheus","namespace":"kube-system","resourceVersion":"10"},"data":{"prometheus.yml":"global: {}"}}`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "prometheus", cm.Name)
+	assert.Equal(t, "kube-system", cm.Namespace)
+	assert.Equal(t, "10", cm.ResourceVersion)
+	assert.Equal(t, map[string]string{"prometheus.yml": "global: {}"}, cm.Data)
+
+	_, err = decodeConfigMap([]byte(`{"metadata":`))
+	assert.Error(t, err)
+}
+
+func TestEncodeConfigMap(t *testing.T) {
+	content, err := encodeConfigMap(&ConfigMap{Name: "prometheus", Namespace: "kube-system", Data: map[string]string{"a": "b"}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	var object map[string]interface{}
+	assert.NoError(t, json.Unmarshal(content, &object))
+	assert.Equal(t, "ConfigMap", object["kind"])
+	assert.Equal(t, "v1", object["apiVersion"])
+	assert.Equal(t, map[string]interface{}{"name": "prometheus", "namespace": "kube-system"}, object["metadata"])
+	assert.Equal(t, map[string]interface{}{"a": "b"}, object["data"])
+}
+
+func TestEncodeConfigMapUpdate(t *testing.T) {
+	cm, err := decodeConfigMap([]byte(`{
+		"kind": "ConfigMap",
+		"apiVersion": "v1",
+		"metadata": {
+			"name": "prometheus",
+			"namespace": "kube-system",
+			"resourceVersion": "10",
+			"labels": {"app": "prometheus"},
+			"annotations": {"owner": "team"},
+			"ownerReferences": [{"kind": "Deployment", "name": "prometheus", "uid": "1234"}]
+		},
+		"data": {"old.yml": "old"}
+	}`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	cm.Data = map[string]string{"prometheus.yml": "global: {}"}
+
+	content, err := encodeConfigMap(cm)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var object map[string]interface{}
+	assert.NoError(t, json.Unmarshal(content, &object))
+	metadata := object["metadata"].(map[string]interface{})
+	assert.Equal(t, "10", metadata["resourceVersion"])
+	assert.Equal(t, map[string]interface{}{"app": "prometheus"}, metadata["labels"])
+	assert.Equal(t, map[string]interface{}{"owner": "team"}, metadata["annotations"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"kind": "Deployment", "name": "prometheus", "uid": "1234"}}, metadata["ownerReferences"])
+	assert.Equal(t, map[string]interface{}{"prometheus.yml": "global: {}"}, object["data"])
+}
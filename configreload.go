@@ -0,0 +1,200 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// the interval the configuration file is checked for changes
+	configFileCheckInterval = 10 * time.Second
+)
+
+// clientOptions are the options used by the client, a change requires new watches
+type clientOptions struct {
+	Host, Namespaces, NamespaceSelector, Kubeconfig, KubeContext             string
+	TokenFile, Token, CaCertFile, ClientCertFile, ClientKeyFile              string
+	Username, Password, TLSServerName, APIVersion, APIProtocol               string
+	PodLabelSelector, PodFieldSelector, NodeLabelSelector, NodeFieldSelector string
+	Port                                                                     int
+	HTTPInsecure, WithNodes, WithPods                                        bool
+}
+
+// getClientOptions returns the client options from the configuration
+func getClientOptions(c Config) clientOptions {
+	return clientOptions{
+		Host:              c.Host,
+		Namespaces:        c.Namespaces,
+		NamespaceSelector: c.NamespaceSelector,
+		Kubeconfig:        c.Kubeconfig,
+		KubeContext:       c.KubeContext,
+		TokenFile:         c.TokenFile,
+		Token:             c.Token,
+		CaCertFile:        c.CaCertFile,
+		ClientCertFile:    c.ClientCertFile,
+		ClientKeyFile:     c.ClientKeyFile,
+		Username:          c.Username,
+		Password:          c.Password,
+		TLSServerName:     c.TLSServerName,
+		APIVersion:        c.APIVersion,
+		APIProtocol:       c.APIProtocol,
+		PodLabelSelector:  c.PodLabelSelector,
+		PodFieldSelector:  c.PodFieldSelector,
+		NodeLabelSelector: c.NodeLabelSelector,
+		NodeFieldSelector: c.NodeFieldSelector,
+		Port:              c.Port,
+		HTTPInsecure:      c.HTTPInsecure,
+		WithNodes:         c.WithNodes,
+		WithPods:          c.WithPods,
+	}
+}
+
+// requestConfigReload asks the service processor to reload the configuration
+func (r *PrometheusK8S) requestConfigReload() {
+	select {
+	case r.reloadCh <- true:
+	default:
+		glog.V(4).Infof("a configuration reload is already pending")
+	}
+}
+
+// reloadConfiguration builds a new configuration from the command line and the configuration file,
+// validates and publishes it, then swaps in the new templates, outputs and watches; on an error
+// the current configuration is kept
+func (r *PrometheusK8S) reloadConfiguration() (err error) {
+	glog.Infof("reloading the configuration of the service")
+	previous := getConfig()
+
+	defer func() {
+		if err != nil {
+			setConfig(previous)
+			configReloadFailuresMetric.Inc()
+			return
+		}
+		configReloadsMetric.Inc()
+	}()
+
+	// step: build and validate the new configuration, the current one is not touched
+	current, err := loadConfig(commandLineArgs)
+	if err != nil {
+		return fmt.Errorf("invalid configuration, error: %s", err)
+	}
+
+	// step: load the user templates
+	templates, err := loadTemplates(current.Templates)
+	if err != nil {
+		return err
+	}
+
+	// step: publish the configuration, the client and outputs below are created from it
+	setConfig(current)
+
+	// step: start new watches if the client has changed, the old ones are stopped afterwards
+	client := r.getClient()
+	shutdownCh := r.shutdownCh
+	if !reflect.DeepEqual(getClientOptions(*previous), getClientOptions(*current)) {
+		glog.Infof("the kubernetes options have changed, recreating the watches")
+		if client, err = r.newClient(); err != nil {
+			return fmt.Errorf("unable to create the kubernetes client, error: %s", err)
+		}
+		if shutdownCh, err = client.Watch(r.updatesCh); err != nil {
+			return fmt.Errorf("unable to watch for events from kubernetes, error: %s", err)
+		}
+		if r.shutdownCh != nil {
+			close(r.shutdownCh)
+		}
+	}
+	r.shutdownCh = shutdownCh
+
+	// step: recreate the reload notifier if the options have changed
+	reloader := r.getReloader()
+	if previous.ReloadURL != current.ReloadURL || previous.ReloadPidFile != current.ReloadPidFile ||
+		previous.ReloadProcess != current.ReloadProcess || previous.DryRun != current.DryRun {
+		pending := false
+		if reloader != nil {
+			pending = reloader.isPending()
+			reloader.stop()
+		}
+		reloader = nil
+		if reloadEnabled() {
+			reloader = newReloadNotifier()
+			// step: carry over a change which has not been reloaded yet
+			if pending {
+				reloader.markPending()
+			}
+		}
+	}
+
+	// step: publish the client and outputs, the http handlers read them from other goroutines
+	r.Lock()
+	r.client = client
+	r.templates = templates
+	r.sink = r.newSink(client)
+	r.reloader = reloader
+	r.Unlock()
+
+	if previous.LeaderElect != current.LeaderElect || previous.LeaderIdentity != current.LeaderIdentity ||
+		previous.LeaderLease != current.LeaderLease {
+		glog.Warningf("the leader election options have changed, a restart is required")
+	}
+	if previous.ListenAddress != current.ListenAddress {
+		glog.Warningf("the listen address has changed to: %s, a restart is required", current.ListenAddress)
+	}
+	if previous.EnableDebug != current.EnableDebug {
+		glog.Warningf("the debug endpoints option has changed, a restart is required")
+	}
+	glog.Infof("successfully reloaded the configuration")
+
+	return nil
+}
+
+// getClient returns the current kubernetes client
+func (r *PrometheusK8S) getClient() KubeAPI {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.client
+}
+
+// getSink returns the current output sink
+func (r *PrometheusK8S) getSink() outputSink {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.sink
+}
+
+// getTemplates returns the current user templates
+func (r *PrometheusK8S) getTemplates() []*outputTemplate {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.templates
+}
+
+// getReloader returns the current reload notifier, nil if not required
+func (r *PrometheusK8S) getReloader() *reloadNotifier {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.reloader
+}
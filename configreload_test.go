@@ -0,0 +1,135 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestConfigReload(t *testing.T, content string) (*PrometheusK8S, func()) {
+	original, args := getConfig(), commandLineArgs
+	// step: the tests change a copy of the configuration
+	c := *original
+	setConfig(&c)
+	directory, err := ioutil.TempDir("", "config-reload")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	filename := filepath.Join(directory, "config.yml")
+	assert.Nil(t, ioutil.WriteFile(filename, []byte(content), 0644))
+	commandLineArgs = []string{"-config-file", filename}
+
+	service := newTestPrometheusK8S(t)
+	service.shutdownCh = make(ShutdownChannel)
+	service.newClient = func() (KubeAPI, error) {
+		return newFakeKubeAPI(t), nil
+	}
+
+	return service, func() {
+		setConfig(original)
+		commandLineArgs = args
+		os.RemoveAll(directory)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	directory, err := ioutil.TempDir("", "config-load")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+	filename := filepath.Join(directory, "config.yml")
+	assert.Nil(t, ioutil.WriteFile(filename, []byte("version: v1\nkubernetes: {port: 6443}\noutput: {format: json}\n"), 0644))
+
+	current := getConfig()
+	c, err := loadConfig([]string{"-config-file", filename, "-format", "yaml", "-v", "2"})
+	assert.Nil(t, err)
+	assert.Equal(t, 6443, c.Port)
+	assert.Equal(t, formatYAML, c.OutputFormat)
+	assert.Equal(t, current, getConfig())
+	// check: the options from both the command line and the file are taken as given explicitly
+	assert.True(t, c.isSet("port"))
+	assert.True(t, c.isSet("format"))
+	assert.False(t, c.isSet("api"))
+	assert.False(t, c.isSet("insecure"))
+
+	// check: the options removed from the file return to their defaults
+	assert.Nil(t, ioutil.WriteFile(filename, []byte("version: v1\n"), 0644))
+	c, err = loadConfig([]string{"-config-file", filename})
+	assert.Nil(t, err)
+	assert.Equal(t, 8001, c.Port)
+	assert.False(t, c.isSet("port"))
+
+	_, err = loadConfig([]string{"-config-file", filename, "-format", "toml"})
+	assert.NotNil(t, err)
+}
+
+func TestReloadConfiguration(t *testing.T) {
+	service, restore := newTestConfigReload(t, "version: v1\noutput: {format: json}\n")
+	defer restore()
+	client := service.client
+
+	assert.Nil(t, service.reloadConfiguration())
+	assert.Equal(t, formatJSON, getConfig().OutputFormat)
+	assert.Equal(t, client, service.client)
+}
+
+func TestReloadConfigurationInvalid(t *testing.T) {
+	service, restore := newTestConfigReload(t, "version: v1\noutput: {format: toml}\n")
+	defer restore()
+	getConfig().OutputFormat = formatJSON
+	sink := service.sink
+
+	assert.NotNil(t, service.reloadConfiguration())
+	assert.Equal(t, formatJSON, getConfig().OutputFormat)
+	assert.Equal(t, sink, service.sink)
+}
+
+func TestReloadConfigurationClient(t *testing.T) {
+	service, restore := newTestConfigReload(t, "version: v1\ndiscovery: {pod_selector: monitoring=enabled}\n")
+	defer restore()
+	shutdownCh := service.shutdownCh
+
+	assert.Nil(t, service.reloadConfiguration())
+	assert.Equal(t, "monitoring=enabled", getConfig().PodLabelSelector)
+	assert.NotEqual(t, shutdownCh, service.shutdownCh)
+	_, open := <-shutdownCh
+	assert.False(t, open)
+}
+
+func TestReloadConfigurationConcurrentReaders(t *testing.T) {
+	service, restore := newTestConfigReload(t, "version: v1\ndiscovery: {pod_selector: monitoring=enabled}\n")
+	defer restore()
+
+	// check: the http handlers may read the client and outputs while a reload replaces them, run with -race
+	doneCh := make(chan bool)
+	go func() {
+		defer close(doneCh)
+		for i := 0; i < 10; i++ {
+			service.explain("default", "web")
+			service.getSink()
+			service.getTemplates()
+			service.getReloader()
+		}
+	}()
+	assert.Nil(t, service.reloadConfiguration())
+	<-doneCh
+	assert.NotNil(t, service.getClient())
+}
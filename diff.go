@@ -0,0 +1,175 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// diffSink compares the generated files with those in the directory, printing the targets and labels
+// which would be added or removed rather than writing anything
+type diffSink struct {
+	// the directory holding the current files
+	directory string
+	// the writer the differences are printed to
+	output io.Writer
+	// the number of files which would change
+	changes int
+}
+
+// write prints the differences between the current file and the content
+func (r *diffSink) write(filename string, content []byte) (bool, error) {
+	current, err := ioutil.ReadFile(filepath.Join(r.directory, filename))
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	// step: files other than the targets can only be compared by content
+	if !isTargetsFile(filename) {
+		if bytes.Equal(current, content) {
+			return false, nil
+		}
+		r.changes++
+		fmt.Fprintf(r.output, "~~~ %s would change\n", filename)
+		return true, nil
+	}
+
+	added, removed, err := diffTargets(current, content)
+	if err != nil {
+		return false, fmt.Errorf("unable to compare the file: %s, error: %s", filename, err)
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return false, nil
+	}
+	r.changes++
+	r.print(filename, added, removed)
+
+	return true, nil
+}
+
+// list returns the files within the directory
+func (r *diffSink) list() ([]string, error) {
+	return (&fileSink{directory: r.directory}).list()
+}
+
+// remove prints the targets of the file which would be removed
+func (r *diffSink) remove(filename string) error {
+	current, err := ioutil.ReadFile(filepath.Join(r.directory, filename))
+	if err != nil {
+		return err
+	}
+	_, removed, err := diffTargets(current, nil)
+	if err != nil {
+		return fmt.Errorf("unable to compare the file: %s, error: %s", filename, err)
+	}
+	r.changes++
+	fmt.Fprintf(r.output, "--- %s would be removed\n", filename)
+	r.print(filename, nil, removed)
+
+	return nil
+}
+
+// print writes the added and removed targets of the file
+func (r *diffSink) print(filename string, added, removed []string) {
+	fmt.Fprintf(r.output, "*** %s\n", filename)
+	for _, target := range removed {
+		fmt.Fprintf(r.output, "- %s\n", target)
+	}
+	for _, target := range added {
+		fmt.Fprintf(r.output, "+ %s\n", target)
+	}
+}
+
+// isTargetsFile checks if the file is one of the target files
+func isTargetsFile(filename string) bool {
+	return filename == getOutputFilename(getConfig().NodesConfigFilename) ||
+		filename == getOutputFilename(getConfig().PodsConfigFilename) ||
+		(splitPods() && getPodsFilenameRegex().MatchString(filename))
+}
+
+// diffTargets compares the target groups by meaning, each target along with the labels of its group,
+// returning those added and removed in a sorted order
+func diffTargets(current, generated []byte) ([]string, []string, error) {
+	before, err := flattenTargets(current)
+	if err != nil {
+		return nil, nil, err
+	}
+	after, err := flattenTargets(generated)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var added, removed []string
+	for target := range after {
+		if !before[target] {
+			added = append(added, target)
+		}
+	}
+	for target := range before {
+		if !after[target] {
+			removed = append(removed, target)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	return added, removed, nil
+}
+
+// flattenTargets decodes the target groups, yaml or json, into a set of the targets with their labels
+func flattenTargets(content []byte) (map[string]bool, error) {
+	var groups []*Targets
+	if err := yaml.Unmarshal(content, &groups); err != nil {
+		return nil, err
+	}
+
+	set := make(map[string]bool, 0)
+	for _, group := range groups {
+		var labels []string
+		for _, name := range sortedKeys(group.Labels) {
+			labels = append(labels, fmt.Sprintf("%s=%q", name, group.Labels[name]))
+		}
+		for _, target := range group.Targets {
+			set[fmt.Sprintf("%s {%s}", target, strings.Join(labels, ", "))] = true
+		}
+	}
+
+	return set, nil
+}
+
+// diffCommand generates the configuration once in the diff mode, returning an exit code of zero
+// if nothing would change, one if something would and two on an error
+func diffCommand(service *PrometheusK8S) int {
+	if err := service.GenerateConfiguration(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to generate the configuration, error: %s\n", err)
+		return 2
+	}
+	if sink, found := service.sink.(*diffSink); found && sink.changes > 0 {
+		return 1
+	}
+
+	return 0
+}
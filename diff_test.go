@@ -0,0 +1,119 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffTargets(t *testing.T) {
+	current := []byte(`
+- targets: ["10.0.0.2:80", "10.0.0.1:80"]
+  labels: {namespace: default, pod: web}
+`)
+	// step: the same groups in a different order and format are not a change
+	same := []byte(`[{"targets": ["10.0.0.1:80", "10.0.0.2:80"], "labels": {"pod": "web", "namespace": "default"}}]`)
+	added, removed, err := diffTargets(current, same)
+	assert.Nil(t, err)
+	assert.Empty(t, added)
+	assert.Empty(t, removed)
+
+	generated := []byte(`
+- targets: ["10.0.0.1:80", "10.0.0.3:80"]
+  labels: {namespace: default, pod: web}
+`)
+	added, removed, err = diffTargets(current, generated)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{`10.0.0.3:80 {namespace="default", pod="web"}`}, added)
+	assert.Equal(t, []string{`10.0.0.2:80 {namespace="default", pod="web"}`}, removed)
+
+	_, _, err = diffTargets([]byte("not: [a list"), generated)
+	assert.NotNil(t, err)
+}
+
+func TestDiffSink(t *testing.T) {
+	directory, err := ioutil.TempDir("", "diff")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+	defer func(format string) { getConfig().OutputFormat = format }(getConfig().OutputFormat)
+	getConfig().OutputFormat = formatYAML
+
+	existing := "- targets: [\"10.0.0.1:80\"]\n  labels: {pod: web}\n"
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(directory, "pods.yml"), []byte(existing), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(directory, "prometheus.yml"), []byte("global: {}\n"), 0644))
+
+	output := new(bytes.Buffer)
+	sink := &diffSink{directory: directory, output: output}
+
+	changed, err := sink.write("pods.yml", []byte("- targets: [10.0.0.1:80]\n  labels: {pod: web}\n"))
+	assert.Nil(t, err)
+	assert.False(t, changed)
+	changed, err = sink.write("prometheus.yml", []byte("global: {}\n"))
+	assert.Nil(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, 0, sink.changes)
+	assert.Empty(t, output.String())
+
+	changed, err = sink.write("pods.yml", []byte("- targets: [10.0.0.2:80]\n  labels: {pod: web}\n"))
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	changed, err = sink.write("nodes.yml", []byte("- targets: [10.50.0.1:4194]\n  labels: {role: node}\n"))
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, 2, sink.changes)
+	assert.Equal(t, `*** pods.yml
+- 10.0.0.1:80 {pod="web"}
++ 10.0.0.2:80 {pod="web"}
+*** nodes.yml
++ 10.50.0.1:4194 {role="node"}
+`, output.String())
+
+	// step: nothing is written in the diff mode
+	content, err := ioutil.ReadFile(filepath.Join(directory, "pods.yml"))
+	assert.Nil(t, err)
+	assert.Equal(t, existing, string(content))
+}
+
+func TestDiffCommand(t *testing.T) {
+	directory, err := ioutil.TempDir("", "diff")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+	defer func(namespaces string, format string) {
+		getConfig().Namespaces, getConfig().OutputFormat = namespaces, format
+	}(getConfig().Namespaces, getConfig().OutputFormat)
+	getConfig().Namespaces = "default,platform"
+	getConfig().OutputFormat = formatYAML
+
+	service, err := newPrometheusK8S(newTestOfflineKubeAPI(t))
+	assert.Nil(t, err)
+	sink := &diffSink{directory: directory, output: new(bytes.Buffer)}
+	service.sink = sink
+	assert.Equal(t, 1, diffCommand(service))
+
+	// step: once the files are in place nothing would change
+	golden, err := ioutil.ReadFile("testdata/golden/pods.yml")
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(directory, "pods.yml"), golden, 0644))
+	sink.changes = 0
+	assert.Equal(t, 0, diffCommand(service))
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// discoveryStore holds the last generated target groups, rendered in the format expected
+// by the prometheus http service discovery
+type discoveryStore struct {
+	sync.RWMutex
+	// the rendered target groups, keyed by name i.e. pods or nodes
+	items map[string]*discoveryItem
+}
+
+// discoveryItem is a rendered set of target groups
+type discoveryItem struct {
+	// the json content
+	content []byte
+	// the etag of the content
+	etag string
+}
+
+// newDiscoveryStore creates a new empty store
+func newDiscoveryStore() *discoveryStore {
+	return &discoveryStore{
+		items: make(map[string]*discoveryItem, 0),
+	}
+}
+
+// newDiscoveryItem renders the target groups
+func newDiscoveryItem(name string, targets []*Targets) (*discoveryItem, error) {
+	// step: prometheus expects an empty list rather than null
+	if targets == nil {
+		targets = []*Targets{}
+	}
+	content, err := json.Marshal(targets)
+	if err != nil {
+		glog.Errorf("failed to render the target groups: %s, error: %s", name, err)
+		return nil, err
+	}
+
+	return &discoveryItem{
+		content: content,
+		etag:    fmt.Sprintf(`"%x"`, sha1.Sum(content)),
+	}, nil
+}
+
+// set renders and stores the target groups under the name
+func (r *discoveryStore) set(name string, targets []*Targets) error {
+	item, err := newDiscoveryItem(name, targets)
+	if err != nil {
+		return err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	r.items[name] = item
+
+	return nil
+}
+
+// setServices renders and stores the pods target groups of all the services under services, of
+// each namespace under services/<namespace> and of each service under services/<namespace>/<name>,
+// removing the namespaces and services which no longer exist
+func (r *discoveryStore) setServices(targets []*Targets) error {
+	services := map[string][]*Targets{"services": targets}
+	for _, target := range targets {
+		namespace := "services/" + target.Labels["namespace"]
+		name := namespace + "/" + target.Labels["pod"]
+		services[namespace] = append(services[namespace], target)
+		services[name] = append(services[name], target)
+	}
+
+	items := make(map[string]*discoveryItem, 0)
+	for name, list := range services {
+		item, err := newDiscoveryItem(name, list)
+		if err != nil {
+			return err
+		}
+		items[name] = item
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	for name := range r.items {
+		if _, found := items[name]; !found && strings.HasPrefix(name, "services/") {
+			delete(r.items, name)
+		}
+	}
+	for name, item := range items {
+		r.items[name] = item
+	}
+
+	return nil
+}
+
+// get retrieves the rendered target groups for the name
+func (r *discoveryStore) get(name string) (*discoveryItem, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	item, found := r.items[name]
+
+	return item, found
+}
+
+// isDiscoveryPath checks the name is one of the paths we serve, pods, nodes, services,
+// services/<namespace> or services/<namespace>/<name>
+func isDiscoveryPath(name string) bool {
+	parts := strings.Split(name, "/")
+	for _, part := range parts {
+		if part == "" {
+			return false
+		}
+	}
+	switch parts[0] {
+	case "pods", "nodes":
+		return len(parts) == 1
+	case "services":
+		return len(parts) <= 3
+	}
+
+	return false
+}
+
+// discoveryHandler serves the target groups, i.e. /sd/pods or /sd/services/<namespace>/<name>,
+// honouring the If-None-Match header
+func (r *PrometheusK8S) discoveryHandler(w http.ResponseWriter, req *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(req.URL.Path, "/sd/"), "/")
+	if !isDiscoveryPath(name) {
+		http.Error(w, fmt.Sprintf("invalid discovery path: %s, expected pods, nodes or services[/<namespace>[/<name>]]", req.URL.Path), http.StatusBadRequest)
+		return
+	}
+
+	item, found := r.discovery.get(name)
+	if !found {
+		http.Error(w, fmt.Sprintf("no target groups found for: %s", name), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("ETag", item.etag)
+	if req.Header.Get("If-None-Match") == item.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(item.content)
+}
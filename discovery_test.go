@@ -0,0 +1,141 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiscoveryStore(t *testing.T) {
+	store := newDiscoveryStore()
+	_, found := store.get("pods")
+	assert.False(t, found)
+
+	assert.Nil(t, store.set("pods", nil))
+	item, found := store.get("pods")
+	assert.True(t, found)
+	assert.Equal(t, "[]", string(item.content))
+
+	target := newTarget()
+	target.Targets = append(target.Targets, "10.0.0.1:80")
+	target.Labels["namespace"] = "default"
+	assert.Nil(t, store.set("pods", []*Targets{target}))
+	updated, _ := store.get("pods")
+	assert.NotEqual(t, item.etag, updated.etag)
+	assert.Equal(t, `[{"targets":["10.0.0.1:80"],"labels":{"namespace":"default"}}]`, string(updated.content))
+}
+
+func TestDiscoveryStoreServices(t *testing.T) {
+	store := newDiscoveryStore()
+	newServiceTarget := func(namespace, service, address string) *Targets {
+		target := newTarget()
+		target.Targets = []string{address}
+		target.Labels["namespace"] = namespace
+		target.Labels["pod"] = service
+		return target
+	}
+
+	assert.Nil(t, store.setServices([]*Targets{
+		newServiceTarget("default", "web", "10.0.0.1:80"),
+		newServiceTarget("default", "web", "10.0.0.1:9100"),
+		newServiceTarget("platform", "api", "10.0.0.2:80"),
+	}))
+	item, found := store.get("services/default/web")
+	assert.True(t, found)
+	var decoded []*Targets
+	assert.Nil(t, json.Unmarshal(item.content, &decoded))
+	assert.Len(t, decoded, 2)
+	_, found = store.get("services/platform/api")
+	assert.True(t, found)
+	item, found = store.get("services/default")
+	assert.True(t, found)
+	assert.Nil(t, json.Unmarshal(item.content, &decoded))
+	assert.Len(t, decoded, 2)
+	item, found = store.get("services")
+	assert.True(t, found)
+	assert.Nil(t, json.Unmarshal(item.content, &decoded))
+	assert.Len(t, decoded, 3)
+
+	// step: the services which no longer exist are removed
+	assert.Nil(t, store.setServices([]*Targets{newServiceTarget("default", "web", "10.0.0.1:80")}))
+	_, found = store.get("services/default/web")
+	assert.True(t, found)
+	_, found = store.get("services/platform/api")
+	assert.False(t, found)
+	_, found = store.get("services/platform")
+	assert.False(t, found)
+
+	// step: the list of all the services is served even when empty
+	assert.Nil(t, store.setServices(nil))
+	item, found = store.get("services")
+	assert.True(t, found)
+	assert.Equal(t, "[]", string(item.content))
+}
+
+func TestIsDiscoveryPath(t *testing.T) {
+	for _, name := range []string{"pods", "nodes", "services", "services/default", "services/default/web"} {
+		assert.True(t, isDiscoveryPath(name), "path: %s", name)
+	}
+	for _, name := range []string{"", "pods/web", "targets", "services/default/web/extra", "services//web"} {
+		assert.False(t, isDiscoveryPath(name), "path: %s", name)
+	}
+}
+
+func TestDiscoveryHandler(t *testing.T) {
+	ks8 := newTestPrometheusK8S(t)
+	server := httptest.NewServer(ks8.newHTTPHandler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/sd/pods")
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = http.Get(server.URL + "/sd/services/default/web/extra")
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	pods, err := ks8.getPods()
+	assert.Nil(t, err)
+	services, _ := ks8.groupPods(pods)
+	targets := ks8.generatePodsConfiguration(pods, services)
+	assert.Nil(t, ks8.discovery.set("pods", targets))
+
+	resp, err = http.Get(server.URL + "/sd/pods")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	etag := resp.Header.Get("ETag")
+	assert.NotEmpty(t, etag)
+	var decoded []*Targets
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&decoded))
+	resp.Body.Close()
+	assert.Equal(t, len(targets), len(decoded))
+
+	req, _ := http.NewRequest("GET", server.URL+"/sd/pods", nil)
+	req.Header.Set("If-None-Match", etag)
+	resp, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+}
@@ -16,14 +16,35 @@ limitations under the License.
 
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"sync"
+)
 
 // PrometheusK8S is the main service wrapper
 type PrometheusK8S struct {
+	// guards the client, templates, reloader and sink, which are replaced on a reload
+	sync.RWMutex
 	// the client for k8s
 	client KubeAPI
 	// the update events
 	updatesCh UpdateEvent
+	// the last generated target groups, served over http
+	discovery *discoveryStore
+	// the user templates rendered on every generation
+	templates []*outputTemplate
+	// the notifier used to reload prometheus, nil if not required
+	reloader *reloadNotifier
+	// the sink the configuration is written to
+	sink outputSink
+	// the channel used to stop the current watches
+	shutdownCh ShutdownChannel
+	// the channel used to request a reload of the configuration
+	reloadCh chan bool
+	// creates a new client when the kubernetes options are reloaded
+	newClient func() (KubeAPI, error)
+	// the leader election, nil if not enabled
+	elector *leaderElector
 }
 
 // Event represents an update event itself
@@ -45,12 +66,24 @@ type UpdateEvent chan *Event
 type KubeAPI interface {
 	// checks to see if a namespace exists
 	NamespaceExists(string) (bool, error)
+	// retrieve the list of namespaces we are generating the pods for
+	Namespaces() ([]string, error)
 	// retrieve a list of nodes from kubernetes
 	Nodes() ([]*Node, error)
 	// retrieve a list of running pods from within a namespace
 	Pods(string) ([]*Pod, error)
-	// watch for changes in nodes and pods and update
+	// retrieve a pod regardless of phase or selectors, nil if it does not exist
+	Pod(string, string) (*Pod, error)
+	// watch for changes in nodes, pods and namespaces and update
 	Watch(UpdateEvent) (ShutdownChannel, error)
+	// retrieve a configmap, nil if it does not exist
+	ConfigMap(string, string) (*ConfigMap, error)
+	// create or update a configmap
+	SaveConfigMap(*ConfigMap) error
+	// retrieve the object used as the leader election lock, nil if it does not exist
+	LeaderLock(string, string, string) (*LeaderLock, error)
+	// create or update the leader election lock
+	SaveLeaderLock(*LeaderLock) error
 }
 
 // Pod is a normalize form of running pod
@@ -67,6 +100,8 @@ type Pod struct {
 	Annotations map[string]string
 	// the ip address of the pod
 	Address string
+	// the phase of the pod
+	Phase string
 }
 
 // Node is the definition of the kubernetes node
@@ -77,23 +112,101 @@ type Node struct {
 	Labels map[string]string
 }
 
+// ConfigMap is the normalized form of a kubernetes configmap
+type ConfigMap struct {
+	// the name of the configmap
+	Name string
+	// the namespace of the configmap
+	Namespace string
+	// the revision of the configmap, empty if it has not been created
+	ResourceVersion string
+	// the content of the configmap
+	Data map[string]string
+	// the decoded object, kept so an update does not lose any of the other fields
+	object map[string]interface{}
+}
+
+// LeaderLock is the object, a configmap or endpoints, holding the leader election record
+type LeaderLock struct {
+	// the resource of the lock, configmaps or endpoints
+	Kind string
+	// the name of the lock
+	Name string
+	// the namespace of the lock
+	Namespace string
+	// the revision of the lock, empty if it has not been created
+	ResourceVersion string
+	// the annotations of the lock
+	Annotations map[string]string
+	// the decoded object, kept so an update does not lose any of the other fields
+	object map[string]interface{}
+}
+
 // Targets is the structure of the prometheus file discovery targets
 type Targets struct {
 	// the array of hosts within this target
-	Targets []string `yaml:"targets",json:"targets"`
+	Targets []string `yaml:"targets" json:"targets"`
 	// the labels associated to these targets
-	Labels map[string]string `yaml:"labels",json:"labels"`
+	Labels map[string]string `yaml:"labels" json:"labels"`
+	// the name of the metrics entry, when the targets are grouped per endpoint
+	endpoint string
+	// the name of the pod of each target, keyed by the address, used to shard by the pod
+	pods map[string]string
 }
 
 // Metrics is the structure used to produce details about the metric endpoints
 // being exported by a pod
 type Metrics struct {
 	// the name of the metric (optional)
-	Name string `yaml:"name,omitempty",json:"name,omitempty"`
+	Name string `yaml:"name,omitempty" json:"name,omitempty"`
 	// the port of the metric
-	Port int `yaml:"port",json:"port"`
+	Port int `yaml:"port" json:"port"`
 	// the endpoint (optional)
-	Endpoint string `yaml:"endpoint,omitempty",json:"endpoint,omitempty"`
+	Endpoint string `yaml:"endpoint,omitempty" json:"endpoint,omitempty"`
+	// the scheme used to scrape, http or https (optional)
+	Scheme string `yaml:"scheme,omitempty" json:"scheme,omitempty"`
+	// the scrape interval (optional)
+	Interval string `yaml:"interval,omitempty" json:"interval,omitempty"`
+	// the scrape timeout (optional)
+	Timeout string `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	// the alerting rules of the entry (optional)
+	Alerts *MetricAlerts `yaml:"alerts,omitempty" json:"alerts,omitempty"`
+}
+
+// MetricAlerts are the alerting rules generated for a metrics entry
+type MetricAlerts struct {
+	// alert when a target is down
+	TargetDown *BasicAlert `yaml:"target_down,omitempty" json:"target_down,omitempty"`
+	// alert when a target returns no samples
+	NoSamples *BasicAlert `yaml:"no_samples,omitempty" json:"no_samples,omitempty"`
+	// alert when the scrape duration of a target is above the threshold
+	ScrapeDuration *BasicAlert `yaml:"scrape_duration,omitempty" json:"scrape_duration,omitempty"`
+	// any other alerting rules
+	Rules []*AlertRule `yaml:"rules,omitempty" json:"rules,omitempty"`
+}
+
+// BasicAlert are the options of one of the basic alerts
+type BasicAlert struct {
+	// the duration the condition must hold before firing (optional)
+	For string `yaml:"for,omitempty" json:"for,omitempty"`
+	// the severity label of the alert (optional)
+	Severity string `yaml:"severity,omitempty" json:"severity,omitempty"`
+	// the threshold of the scrape duration i.e. 2s (optional)
+	Threshold string `yaml:"threshold,omitempty" json:"threshold,omitempty"`
+}
+
+// AlertRule is an alerting rule given in full
+type AlertRule struct {
+	// the name of the alert
+	Alert string `yaml:"alert" json:"alert"`
+	// the expression of the alert
+	Expr string `yaml:"expr" json:"expr"`
+	// the duration the condition must hold before firing (optional)
+	For string `yaml:"for,omitempty" json:"for,omitempty"`
+	// the labels added to the alert (optional)
+	Labels map[string]string `yaml:"labels,omitempty" json:"labels,omitempty"`
+	// the annotations added to the alert (optional)
+	Annotations map[string]string `yaml:"annotations,omitempty" json:"annotations,omitempty"`
 }
 
 func (r Pod) String() string {
@@ -104,5 +217,6 @@ Namespace: %s
 Labels: %s
 Annotations: %s
 Address: %s
-`, r.ID, r.Name, r.Namespace, r.Labels, r.Annotations, r.Address)
+Phase: %s
+`, r.ID, r.Name, r.Namespace, r.Labels, r.Annotations, r.Address, r.Phase)
 }
@@ -17,12 +17,22 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"path/filepath"
+	"strings"
 
 	"github.com/golang/glog"
 	"gopkg.in/yaml.v2"
 )
 
+const (
+	// the yaml output format
+	formatYAML = "yaml"
+	// the json output format
+	formatJSON = "json"
+)
+
 // encode converts / marshall the data structure into the specified format
 func encode(data interface{}) (output []byte, err error) {
 	output, err = yaml.Marshal(data)
@@ -33,6 +43,45 @@ func encode(data interface{}) (output []byte, err error) {
 	return
 }
 
+// encodeAs converts / marshall the data structure into the format, i.e. yaml or json
+func encodeAs(data interface{}, format string) ([]byte, error) {
+	switch format {
+	case formatJSON:
+		output, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			glog.Errorf("Failed to marshall the structure to json, %s, error: %s", data, err)
+			return nil, fmt.Errorf("marshalling failure, data: %v, error: %s", data, err)
+		}
+		return append(output, '\n'), nil
+	default:
+		return encode(data)
+	}
+}
+
+// getOutputFilename returns the filename with the extension matching the output format; only the
+// yaml and json extensions are swapped, any other filename is kept as given
+func getOutputFilename(filename string) string {
+	extension := filepath.Ext(filename)
+	switch extension {
+	case ".json", ".yml", ".yaml":
+	default:
+		return filename
+	}
+
+	switch getConfig().OutputFormat {
+	case formatJSON:
+		if extension == ".json" {
+			return filename
+		}
+		return strings.TrimSuffix(filename, extension) + ".json"
+	default:
+		if extension == ".yml" || extension == ".yaml" {
+			return filename
+		}
+		return strings.TrimSuffix(filename, extension) + ".yml"
+	}
+}
+
 // decode parses and decodes the string into a actual structure
 func decode(input []byte, output interface{}) error {
 	err := yaml.Unmarshal(input, output)
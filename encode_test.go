@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -51,3 +52,36 @@ func TestDecode(t *testing.T) {
 	assert.Equal(t, decoded.Port, 9090)
 	assert.Equal(t, decoded.Endpoint, "/metrics")
 }
+
+func TestEncodeAsJSON(t *testing.T) {
+	target := newTarget()
+	target.Targets = append(target.Targets, "10.0.0.1:9103")
+	target.Labels["namespace"] = "default"
+
+	content, err := encodeAs([]*Targets{target}, formatJSON)
+	assert.Nil(t, err)
+	var decoded []map[string]interface{}
+	assert.Nil(t, json.Unmarshal(content, &decoded))
+	assert.Equal(t, []interface{}{"10.0.0.1:9103"}, decoded[0]["targets"])
+	assert.Equal(t, map[string]interface{}{"namespace": "default"}, decoded[0]["labels"])
+
+	content, err = encodeAs([]*Targets{target}, formatYAML)
+	assert.Nil(t, err)
+	assert.Contains(t, string(content), "targets:")
+}
+
+func TestGetOutputFilename(t *testing.T) {
+	defer func(format string) { getConfig().OutputFormat = format }(getConfig().OutputFormat)
+
+	getConfig().OutputFormat = formatYAML
+	assert.Equal(t, "pods.yml", getOutputFilename("pods.yml"))
+	assert.Equal(t, "pods.yaml", getOutputFilename("pods.yaml"))
+	assert.Equal(t, "pods.yml", getOutputFilename("pods.json"))
+	assert.Equal(t, "pods", getOutputFilename("pods"))
+
+	getConfig().OutputFormat = formatJSON
+	assert.Equal(t, "pods.json", getOutputFilename("pods.yml"))
+	assert.Equal(t, "pods.json", getOutputFilename("pods.json"))
+	assert.Equal(t, "pods.v1", getOutputFilename("pods.v1"))
+	assert.Equal(t, "targets", getOutputFilename("targets"))
+}
@@ -18,11 +18,15 @@ package main
 
 import (
 	"fmt"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/watch"
 )
 
 const (
-	nodeEvent = 1
-	podEvent  = 2
+	nodeEvent      = 1
+	podEvent       = 2
+	namespaceEvent = 3
 )
 
 func (r Event) String() string {
@@ -30,9 +34,25 @@ func (r Event) String() string {
 }
 
 func (r Event) getEventType() string {
-	switch r.Type {
+	return eventTypeName(r.Type)
+}
+
+// getEventAction returns the action of the watch event, i.e. added, modified or deleted
+func (r Event) getEventAction() string {
+	if update, ok := r.Event.(watch.Event); ok {
+		return strings.ToLower(string(update.Type))
+	}
+
+	return "unknown"
+}
+
+// eventTypeName returns the name of the resource for the event type
+func eventTypeName(eventType int) string {
+	switch eventType {
 	case nodeEvent:
 		return "node"
+	case namespaceEvent:
+		return "namespace"
 	default:
 		return "pod"
 	}
@@ -0,0 +1,378 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/api"
+)
+
+// explainStep is a single check of the generation pipeline
+type explainStep struct {
+	// the name of the check
+	Check string `json:"check"`
+	// indicates the pod passed the check
+	Passed bool `json:"passed"`
+	// the details of the outcome
+	Detail string `json:"detail"`
+}
+
+// explainJob is a scrape job the targets of the pod are passed through
+type explainJob struct {
+	// the name of the job
+	Name string `json:"name"`
+	// the targets kept by the relabel rules
+	Kept []string `json:"kept"`
+	// the targets dropped by the relabel rules
+	Dropped []string `json:"dropped"`
+}
+
+// explanation describes why a pod was or was not discovered
+type explanation struct {
+	// the namespace/name of the pod
+	Pod string `json:"pod"`
+	// indicates the pod produces targets
+	Discovered bool `json:"discovered"`
+	// the checks the pod went through
+	Steps []*explainStep `json:"steps"`
+	// the target groups the pod contributes to
+	Targets []*Targets `json:"targets,omitempty"`
+	// the files the target groups are written to
+	Files []string `json:"files,omitempty"`
+	// the scrape jobs the targets are passed through
+	Jobs []*explainJob `json:"jobs,omitempty"`
+}
+
+// step adds a check to the explanation, returning the outcome
+func (r *explanation) step(check string, passed bool, detail string, args ...interface{}) bool {
+	r.Steps = append(r.Steps, &explainStep{Check: check, Passed: passed, Detail: fmt.Sprintf(detail, args...)})
+
+	return passed
+}
+
+// String renders the explanation as text
+func (r *explanation) String() string {
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "pod: %s\n", r.Pod)
+	for _, step := range r.Steps {
+		outcome := "ok"
+		if !step.Passed {
+			outcome = "FAILED"
+		}
+		fmt.Fprintf(b, "  [%s] %s: %s\n", outcome, step.Check, step.Detail)
+	}
+	for _, target := range r.Targets {
+		fmt.Fprintf(b, "  targets: %s\n", strings.Join(target.Targets, ", "))
+		for _, name := range sortedKeys(target.Labels) {
+			fmt.Fprintf(b, "    label: %s=%s\n", name, target.Labels[name])
+		}
+	}
+	for _, filename := range r.Files {
+		fmt.Fprintf(b, "  file: %s\n", filename)
+	}
+	for _, job := range r.Jobs {
+		fmt.Fprintf(b, "  job: %s, kept: [%s], dropped: [%s]\n", job.Name, strings.Join(job.Kept, ", "), strings.Join(job.Dropped, ", "))
+	}
+	if r.Discovered {
+		fmt.Fprintf(b, "the pod is discovered\n")
+	} else {
+		fmt.Fprintf(b, "the pod is NOT discovered\n")
+	}
+
+	return b.String()
+}
+
+// explain runs a single pod through the generation pipeline, recording each of the checks
+func (r *PrometheusK8S) explain(namespace, name string) (*explanation, error) {
+	e := &explanation{Pod: namespace + "/" + name}
+	client := r.getClient()
+
+	// step: is the namespace one we are generating for?
+	namespaces, err := client.Namespaces()
+	if err != nil {
+		return nil, err
+	}
+	selected := false
+	for i, x := range namespaces {
+		if x == namespace || x == api.NamespaceAll {
+			selected = true
+		}
+		if x == api.NamespaceAll {
+			namespaces[i] = "all"
+		}
+	}
+	if !e.step("pods", getConfig().WithPods, "the generation of the pods is enabled: %t", getConfig().WithPods) {
+		return e, nil
+	}
+	if !e.step("namespace selected", selected, "generating for the namespaces: %s", strings.Join(namespaces, ",")) {
+		return e, nil
+	}
+	found, err := client.NamespaceExists(namespace)
+	if err != nil {
+		return nil, err
+	}
+	if !e.step("namespace exists", found, "the namespace: %s exists: %t", namespace, found) {
+		return e, nil
+	}
+
+	// step: does the pod exist and is it running?
+	pod, err := client.Pod(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	if !e.step("pod exists", pod != nil, "the pod: %s exists: %t", name, pod != nil) {
+		return e, nil
+	}
+	if !e.step("phase", pod.Phase == string(api.PodRunning), "the pod is in the phase: %s, only running pods are discovered", pod.Phase) {
+		return e, nil
+	}
+	e.step("address", pod.Address != "", "the pod address is: %q", pod.Address)
+
+	// step: was the pod filtered out by the selectors?
+	pods, err := client.Pods(namespace)
+	if err != nil {
+		return nil, err
+	}
+	matched := false
+	for _, x := range pods {
+		if x.ID == pod.ID {
+			matched = true
+		}
+	}
+	if !e.step("selectors", matched, "the pod label selector: %q, field selector: %q", getConfig().PodLabelSelector, getConfig().PodFieldSelector) {
+		return e, nil
+	}
+
+	// step: check the annotation
+	annotation, found := pod.Annotations[getConfig().MetricAnnotation]
+	if !e.step("annotation", found, "the pod has the annotation: %s: %t", getConfig().MetricAnnotation, found) {
+		return e, nil
+	}
+	metrics, err := decodeMetrics(annotation)
+	if !e.step("annotation decoded", err == nil, "%s", describeDecode(metrics, err)) {
+		return e, nil
+	}
+
+	// step: find the group the pod belongs to
+	key := serviceKey{namespace: pod.Namespace, name: pod.Name}
+	services, _ := r.groupPods(pods)
+	groupMetrics, found := services[key]
+	if !e.step("group", found, "the pod is grouped by the name label under: %s/%s", key.namespace, key.name) {
+		return e, nil
+	}
+	source := pods[indexOfGroupSource(pods, key)]
+	e.step("group annotation", annotation == source.Annotations[getConfig().MetricAnnotation], "the group uses the annotation of the pod: %s", source.ID)
+
+	// step: find the target groups containing the pod
+	addresses := make(map[string]bool, 0)
+	for _, metric := range groupMetrics {
+		addresses[fmt.Sprintf("%s:%d", pod.Address, metric.Port)] = true
+	}
+	for _, target := range r.generatePodsConfiguration(pods, map[serviceKey][]*Metrics{key: groupMetrics}) {
+		for _, address := range target.Targets {
+			if addresses[address] {
+				e.Targets = append(e.Targets, target)
+				if getConfig().Shards > 0 {
+					shard := getShard(getShardKey(key.namespace, key.name, pod.ID), getConfig().Shards)
+					e.Files = append(e.Files, getShardFilename(shard))
+				} else {
+					e.Files = append(e.Files, getPodsFilename(key.namespace, key.name, target.endpoint))
+				}
+				break
+			}
+		}
+	}
+	if !e.step("targets", len(e.Targets) > 0, "the pod produces %d target groups", len(e.Targets)) {
+		return e, nil
+	}
+	e.Discovered = true
+
+	// step: run the targets through the relabel rules of the service jobs
+	if getConfig().ScrapeConfigBase != "" && getConfig().ScrapeJobs == scrapeJobsPerService {
+		e.Discovered = false
+		for _, job := range generateServiceScrapeJobs(map[serviceKey][]*Metrics{key: groupMetrics}) {
+			result := &explainJob{Name: job.JobName}
+			for i, target := range e.Targets {
+				if !containsString(job.FileSDConfigs[0].Files, e.Files[i]) {
+					continue
+				}
+				for _, address := range target.Targets {
+					if !addresses[address] {
+						continue
+					}
+					if relabelKeeps(job.RelabelConfigs, target.Labels, address) {
+						result.Kept = append(result.Kept, address)
+						e.Discovered = true
+					} else {
+						result.Dropped = append(result.Dropped, address)
+					}
+				}
+			}
+			e.Jobs = append(e.Jobs, result)
+		}
+		e.step("relabel", e.Discovered, "the targets are kept by the relabel rules of %d jobs", len(e.Jobs))
+	}
+
+	return e, nil
+}
+
+// describeDecode describes the outcome of decoding the annotation
+func describeDecode(metrics []*Metrics, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	var entries []string
+	for _, metric := range metrics {
+		entries = append(entries, fmt.Sprintf("%s (port: %d)", metricName(metric), metric.Port))
+	}
+
+	return fmt.Sprintf("the annotation has the entries: %s", strings.Join(entries, ", "))
+}
+
+// indexOfGroupSource returns the index of the pod whose annotation is used for the group
+func indexOfGroupSource(pods []*Pod, key serviceKey) int {
+	for i, pod := range pods {
+		if pod.Namespace != key.namespace || pod.Name != key.name {
+			continue
+		}
+		if _, found := pod.Annotations[getConfig().MetricAnnotation]; !found {
+			continue
+		}
+		if _, err := decodeMetrics(pod.Annotations[getConfig().MetricAnnotation]); err == nil {
+			return i
+		}
+	}
+
+	return 0
+}
+
+// relabelKeeps checks if the target is kept by the keep and drop relabel rules
+func relabelKeeps(rules []*relabelConfig, targetLabels map[string]string, address string) bool {
+	for _, rule := range rules {
+		var values []string
+		for _, name := range rule.SourceLabels {
+			if name == "__address__" {
+				values = append(values, address)
+				continue
+			}
+			values = append(values, targetLabels[name])
+		}
+		matched := regexp.MustCompile("^(?:" + rule.Regex + ")$").MatchString(strings.Join(values, ";"))
+		if (rule.Action == "keep" && !matched) || (rule.Action == "drop" && matched) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// containsString checks if the list contains the value
+func containsString(list []string, value string) bool {
+	for _, x := range list {
+		if x == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parsePodReference splits the namespace/pod reference
+func parsePodReference(reference string) (string, string, error) {
+	items := strings.Split(reference, "/")
+	if len(items) != 2 || items[0] == "" || items[1] == "" {
+		return "", "", fmt.Errorf("invalid pod: %s, should be in the format of namespace/pod", reference)
+	}
+
+	return items[0], items[1], nil
+}
+
+// explainHandler explains the pod given in the pod query parameter, i.e. /debug/explain?pod=default/web-1
+func (r *PrometheusK8S) explainHandler(w http.ResponseWriter, req *http.Request) {
+	namespace, name, err := parsePodReference(req.URL.Query().Get("pod"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	e, err := r.explain(namespace, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(e)
+}
+
+// explainCommand is the explain subcommand, it explains why a pod was or was not discovered, either
+// from the cluster or the manifests given after the pod
+func explainCommand(args []string, output io.Writer) int {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s explain [options] <namespace>/<pod> [file or directory]...\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	if err := parseConfigArgs(args); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration, error: %s\n", err)
+		return 2
+	}
+	if flag.NArg() == 0 {
+		flag.Usage()
+		return 2
+	}
+	namespace, name, err := parsePodReference(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	// step: use the manifests if given, else the cluster
+	var client KubeAPI
+	if flag.NArg() > 1 {
+		client, err = newOfflineKubeAPI(flag.Args()[1:])
+	} else {
+		client, err = NewKubeAPI()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create the kubernetes client, error: %s\n", err)
+		return 1
+	}
+	service, err := newPrometheusK8S(client)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create the service, error: %s\n", err)
+		return 1
+	}
+
+	e, err := service.explain(namespace, name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to explain the pod, error: %s\n", err)
+		return 1
+	}
+	fmt.Fprint(output, e)
+	if !e.Discovered {
+		return 1
+	}
+
+	return 0
+}
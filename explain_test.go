@@ -0,0 +1,159 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestExplainService(t *testing.T) *PrometheusK8S {
+	service, err := newPrometheusK8S(newTestOfflineKubeAPI(t))
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	service.sink = newFakeSink()
+
+	return service
+}
+
+// failedCheck returns the name of the first failed check
+func failedCheck(e *explanation) string {
+	for _, step := range e.Steps {
+		if !step.Passed {
+			return step.Check
+		}
+	}
+
+	return ""
+}
+
+func TestExplain(t *testing.T) {
+	defer func(namespaces string) { getConfig().Namespaces = namespaces }(getConfig().Namespaces)
+	getConfig().Namespaces = "default,platform"
+	service := newTestExplainService(t)
+
+	e, err := service.explain("default", "nginx-a1b2c")
+	assert.Nil(t, err)
+	assert.True(t, e.Discovered)
+	assert.Empty(t, failedCheck(e))
+	if assert.Len(t, e.Targets, 1) {
+		assert.Equal(t, "nginx", e.Targets[0].Labels["pod"])
+		assert.Contains(t, e.Targets[0].Targets, "10.10.0.100:9113")
+	}
+	assert.Equal(t, []string{"pods.yml"}, e.Files)
+
+	for pod, check := range map[string]string{
+		"default/nginx-g5h6i":  "phase",
+		"default/redis-j7k8l":  "annotation",
+		"default/missing":      "pod exists",
+		"kube-system/dns-q1r2": "namespace selected",
+	} {
+		namespace, name, err := parsePodReference(pod)
+		assert.Nil(t, err)
+		e, err := service.explain(namespace, name)
+		assert.Nil(t, err)
+		assert.False(t, e.Discovered, "pod: %s", pod)
+		assert.Equal(t, check, failedCheck(e), "pod: %s", pod)
+	}
+
+	// step: the selectors are reported
+	defer func(selector string) { getConfig().PodLabelSelector = selector }(getConfig().PodLabelSelector)
+	getConfig().PodLabelSelector = "tier=backend"
+	e, err = service.explain("default", "nginx-a1b2c")
+	assert.Nil(t, err)
+	assert.Equal(t, "selectors", failedCheck(e))
+}
+
+func TestExplainMetricsUnchanged(t *testing.T) {
+	defer func(namespaces string) { getConfig().Namespaces = namespaces }(getConfig().Namespaces)
+	getConfig().Namespaces = "default,platform"
+	service := newTestExplainService(t)
+
+	gauge := targetsMetric.WithLabelValues("pods.yml")
+	gauge.Set(-1)
+	defer targetsMetric.DeleteLabelValues("pods.yml")
+
+	_, err := service.explain("default", "nginx-a1b2c")
+	assert.Nil(t, err)
+	metric := &dto.Metric{}
+	assert.Nil(t, gauge.Write(metric))
+	assert.Equal(t, float64(-1), metric.GetGauge().GetValue())
+}
+
+func TestExplainServiceJobs(t *testing.T) {
+	defer func(namespaces, base, jobs string) {
+		getConfig().Namespaces, getConfig().ScrapeConfigBase, getConfig().ScrapeJobs = namespaces, base, jobs
+	}(getConfig().Namespaces, getConfig().ScrapeConfigBase, getConfig().ScrapeJobs)
+	getConfig().Namespaces = "platform"
+	getConfig().ScrapeConfigBase = "testdata/prometheus-base.yml"
+	getConfig().ScrapeJobs = scrapeJobsPerService
+	service := newTestExplainService(t)
+
+	e, err := service.explain("platform", "prometheus-m9n0p")
+	assert.Nil(t, err)
+	assert.True(t, e.Discovered)
+	if assert.Len(t, e.Jobs, 2) {
+		assert.Equal(t, "platform/prometheus/prometheus", e.Jobs[0].Name)
+		assert.Equal(t, []string{"10.10.2.10:9090"}, e.Jobs[0].Kept)
+		assert.Equal(t, []string{"10.10.2.10:9103"}, e.Jobs[0].Dropped)
+	}
+}
+
+func TestExplainHandler(t *testing.T) {
+	defer func(namespaces string, debug bool) {
+		getConfig().Namespaces, getConfig().EnableDebug = namespaces, debug
+	}(getConfig().Namespaces, getConfig().EnableDebug)
+	getConfig().Namespaces = "default"
+	service := newTestExplainService(t)
+
+	// check: the endpoint is not served unless enabled
+	disabled := httptest.NewServer(service.newHTTPHandler())
+	resp, err := http.Get(disabled.URL + "/debug/explain?pod=default/nginx-a1b2c")
+	assert.Nil(t, err)
+	resp.Body.Close()
+	disabled.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	getConfig().EnableDebug = true
+	server := httptest.NewServer(service.newHTTPHandler())
+	defer server.Close()
+
+	resp, err = http.Get(server.URL + "/debug/explain?pod=default/nginx-a1b2c")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var decoded explanation
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&decoded))
+	assert.True(t, decoded.Discovered)
+
+	resp, err = http.Get(server.URL + "/debug/explain?pod=invalid")
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestExplanationString(t *testing.T) {
+	e := &explanation{Pod: "default/web"}
+	e.step("pod exists", false, "the pod: %s exists: %t", "web", false)
+	assert.Equal(t, "pod: default/web\n  [FAILED] pod exists: the pod: web exists: false\nthe pod is NOT discovered\n", e.String())
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// the interval the event loop reports it is alive
+	heartbeatInterval = 10 * time.Second
+	// the time after which we consider the event loop wedged
+	maxHeartbeatLag = 2 * time.Minute
+)
+
+// serviceStatus tracks the state of the event loop, the watches and the generation, used
+// to answer the health and readiness checks
+type serviceStatus struct {
+	sync.RWMutex
+	// the last time the event loop was seen
+	heartbeat time.Time
+	// indicates the watches have been started
+	watching bool
+	// the watches which are currently disconnected
+	disconnected map[string]bool
+	// the time of the last successful generation
+	lastGeneration time.Time
+	// the error from the last generation if any
+	lastGenerationError error
+}
+
+// statusResponse is the json body returned from the health and readiness checks
+type statusResponse struct {
+	// the status i.e. ok or failed
+	Status string `json:"status"`
+	// the reason the check failed
+	Reason string `json:"reason,omitempty"`
+	// the last time the event loop was seen
+	LastHeartbeat *time.Time `json:"last_heartbeat,omitempty"`
+	// the time of the last successful generation
+	LastGeneration *time.Time `json:"last_generation,omitempty"`
+	// the error from the last generation
+	LastGenerationError string `json:"last_generation_error,omitempty"`
+	// the watches which are currently disconnected
+	DisconnectedWatches []string `json:"disconnected_watches,omitempty"`
+}
+
+var (
+	// the current state of the service
+	status = newServiceStatus()
+)
+
+// newServiceStatus creates a new service status
+func newServiceStatus() *serviceStatus {
+	return &serviceStatus{
+		disconnected: make(map[string]bool, 0),
+	}
+}
+
+// markHeartbeat records the event loop is alive
+func (r *serviceStatus) markHeartbeat() {
+	r.Lock()
+	defer r.Unlock()
+	r.heartbeat = time.Now()
+}
+
+// markWatching records the watches have been started
+func (r *serviceStatus) markWatching() {
+	r.Lock()
+	defer r.Unlock()
+	r.watching = true
+}
+
+// setWatchConnected records the watch is connected, or no longer required
+func (r *serviceStatus) setWatchConnected(resource string) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.disconnected, resource)
+}
+
+// setWatchDisconnected records the watch has been disconnected
+func (r *serviceStatus) setWatchDisconnected(resource string) {
+	r.Lock()
+	defer r.Unlock()
+	r.disconnected[resource] = true
+}
+
+// setGeneration records the outcome of a generation
+func (r *serviceStatus) setGeneration(err error) {
+	r.Lock()
+	defer r.Unlock()
+	r.lastGenerationError = err
+	if err == nil {
+		r.lastGeneration = time.Now()
+	}
+}
+
+// health checks the event loop is not wedged
+func (r *serviceStatus) health() *statusResponse {
+	r.RLock()
+	defer r.RUnlock()
+	resp := r.response()
+
+	// check: the heartbeat is only set once the event loop has started
+	if !r.heartbeat.IsZero() && time.Since(r.heartbeat) > maxHeartbeatLag {
+		resp.Status = "failed"
+		resp.Reason = "the event loop has not been seen since " + r.heartbeat.Format(time.RFC3339)
+	}
+
+	return resp
+}
+
+// readiness checks we have generated the configuration and the watches are connected
+func (r *serviceStatus) readiness() *statusResponse {
+	r.RLock()
+	defer r.RUnlock()
+	resp := r.response()
+
+	switch {
+	case r.lastGeneration.IsZero():
+		resp.Status = "failed"
+		resp.Reason = "the configuration has not been generated yet"
+	case !r.watching:
+		resp.Status = "failed"
+		resp.Reason = "the watches have not been started yet"
+	case len(r.disconnected) > 0:
+		resp.Status = "failed"
+		resp.Reason = "one or more of the watches are disconnected"
+	}
+
+	return resp
+}
+
+// response creates a status response from the current state, the caller must hold the lock
+func (r *serviceStatus) response() *statusResponse {
+	resp := &statusResponse{Status: "ok"}
+	if !r.heartbeat.IsZero() {
+		heartbeat := r.heartbeat
+		resp.LastHeartbeat = &heartbeat
+	}
+	if !r.lastGeneration.IsZero() {
+		generation := r.lastGeneration
+		resp.LastGeneration = &generation
+	}
+	if r.lastGenerationError != nil {
+		resp.LastGenerationError = r.lastGenerationError.Error()
+	}
+	for resource := range r.disconnected {
+		resp.DisconnectedWatches = append(resp.DisconnectedWatches, resource)
+	}
+	sort.Strings(resp.DisconnectedWatches)
+
+	return resp
+}
+
+// healthHandler is the http handler for the health check
+func healthHandler(w http.ResponseWriter, req *http.Request) {
+	writeStatusResponse(w, status.health())
+}
+
+// readinessHandler is the http handler for the readiness check
+func readinessHandler(w http.ResponseWriter, req *http.Request) {
+	writeStatusResponse(w, status.readiness())
+}
+
+// writeStatusResponse writes the status response as json
+func writeStatusResponse(w http.ResponseWriter, resp *statusResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	if resp.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
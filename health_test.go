@@ -0,0 +1,91 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceStatusHealth(t *testing.T) {
+	state := newServiceStatus()
+	assert.Equal(t, "ok", state.health().Status)
+
+	state.markHeartbeat()
+	assert.Equal(t, "ok", state.health().Status)
+	assert.NotNil(t, state.health().LastHeartbeat)
+
+	state.heartbeat = time.Now().Add(-2 * maxHeartbeatLag)
+	resp := state.health()
+	assert.Equal(t, "failed", resp.Status)
+	assert.NotEmpty(t, resp.Reason)
+}
+
+func TestServiceStatusReadiness(t *testing.T) {
+	state := newServiceStatus()
+	assert.Equal(t, "failed", state.readiness().Status)
+
+	state.setGeneration(errors.New("failed"))
+	resp := state.readiness()
+	assert.Equal(t, "failed", resp.Status)
+	assert.Equal(t, "failed", resp.LastGenerationError)
+
+	state.setGeneration(nil)
+	assert.Equal(t, "failed", state.readiness().Status)
+	state.markWatching()
+	assert.Equal(t, "ok", state.readiness().Status)
+
+	state.setWatchDisconnected("nodes")
+	resp = state.readiness()
+	assert.Equal(t, "failed", resp.Status)
+	assert.Equal(t, []string{"nodes"}, resp.DisconnectedWatches)
+
+	state.setWatchConnected("nodes")
+	assert.Equal(t, "ok", state.readiness().Status)
+}
+
+func TestReadinessHandler(t *testing.T) {
+	defer func(original *serviceStatus) { status = original }(status)
+	status = newServiceStatus()
+
+	ks8 := newTestPrometheusK8S(t)
+	server := httptest.NewServer(ks8.newHTTPHandler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/readyz")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	resp.Body.Close()
+
+	status.markWatching()
+	assert.Nil(t, ks8.GenerateConfiguration())
+	resp, err = http.Get(server.URL + "/readyz")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var decoded statusResponse
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&decoded))
+	assert.Equal(t, "ok", decoded.Status)
+	assert.NotNil(t, decoded.LastGeneration)
+}
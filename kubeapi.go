@@ -19,26 +19,72 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/golang/glog"
 	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/errors"
 	"k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/client/unversioned/clientcmd"
 	"k8s.io/kubernetes/pkg/fields"
 	"k8s.io/kubernetes/pkg/labels"
 	"k8s.io/kubernetes/pkg/watch"
 )
 
+const (
+	// the service account token mounted into every pod
+	serviceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	// the service account certificate authority mounted into every pod
+	serviceAccountCAFile = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	// the maximum time we wait between attempts to recreate a watch
+	maxWatchBackoff = 30 * time.Second
+)
+
 // Implements the KubeAPI service interface
 type kubeAPIImpl struct {
 	// the kubernetes api client
 	client *unversioned.Client
+	// the label selector used when listing and watching pods
+	podLabels labels.Selector
+	// the field selector used when listing and watching pods
+	podFields fields.Selector
+	// the label selector used when listing and watching nodes
+	nodeLabels labels.Selector
+	// the field selector used when listing and watching nodes
+	nodeFields fields.Selector
+	// the label selector used to select the namespaces, nil when using a static list
+	namespaceSelector labels.Selector
+	// the namespaces currently selected and a lock to protect them
+	namespaces *namespaceSet
 }
 
 // NewKubeAPI ... creates a new watch service for kubernetes
 func NewKubeAPI() (KubeAPI, error) {
-	glog.Infof("Creating a new Kube API service, api: %s", getURL())
+	glog.Infof("Creating a new Kube API service")
+	var err error
 	service := new(kubeAPIImpl)
+
+	// step: parse the selectors for pods and nodes
+	service.podLabels, service.podFields, err = parseSelectors(getConfig().PodLabelSelector, getConfig().PodFieldSelector)
+	if err != nil {
+		return nil, err
+	}
+	service.nodeLabels, service.nodeFields, err = parseSelectors(getConfig().NodeLabelSelector, getConfig().NodeFieldSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	// step: are we selecting the namespaces by labels?
+	if getConfig().NamespaceSelector != "" {
+		if service.namespaceSelector, err = labels.Parse(getConfig().NamespaceSelector); err != nil {
+			return nil, fmt.Errorf("invalid namespace selector: %s, error: %s", getConfig().NamespaceSelector, err)
+		}
+		service.namespaces = newNamespaceSet()
+	}
+
 	kube, err := service.newAPIClient()
 	if err != nil {
 		return nil, err
@@ -49,29 +95,34 @@ func NewKubeAPI() (KubeAPI, error) {
 }
 
 // NamespaceExists checks to see if a namespace exists in k8s
-func (r kubeAPIImpl) NamespaceExists(namespace string) (bool, error) {
+func (r *kubeAPIImpl) NamespaceExists(namespace string) (bool, error) {
 	glog.V(10).Infof("checking for namespace: %s", namespace)
 	if namespace == api.NamespaceAll {
 		return true, nil
 	}
-
-	namespaces, err := r.client.Namespaces().List(labels.Everything(), fields.Everything())
-	if err != nil {
-		return false, err
+	// check: if we are watching the selected namespaces, we can use those
+	if r.namespaceSelector != nil && r.namespaces.synced() {
+		return r.namespaces.has(namespace), nil
 	}
 
-	for _, name := range namespaces.Items {
-		if name.Name == namespace {
+	if _, err := r.client.Namespaces().Get(namespace); err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		// check: we might only have permissions within the namespace, in which case we assume it exists
+		if errors.IsForbidden(err) {
+			glog.V(4).Infof("not permitted to check the namespace: %s, assuming it exists", namespace)
 			return true, nil
 		}
+		return false, err
 	}
 
-	return false, nil
+	return true, nil
 }
 
 // Nodes retrieves a list of nodes from Kuberntes, normalize them and give me the list
-func (r kubeAPIImpl) Nodes() ([]*Node, error) {
-	nodes, err := r.client.Nodes().List(labels.Everything(), fields.Everything())
+func (r *kubeAPIImpl) Nodes() ([]*Node, error) {
+	nodes, err := r.client.Nodes().List(r.nodeLabels, r.nodeFields)
 	if err != nil {
 		glog.Errorf("Failed to retrieve a list of nodes from the api, error: %s", err)
 		return nil, err
@@ -92,13 +143,13 @@ func (r kubeAPIImpl) Nodes() ([]*Node, error) {
 }
 
 // Pods retrieves a list of running within the namespace
-func (r kubeAPIImpl) Pods(namespace string) ([]*Pod, error) {
+func (r *kubeAPIImpl) Pods(namespace string) ([]*Pod, error) {
 	glog.V(10).Infof("Retrieving a list of the running pods")
 
 	// step: get a list of the pods and find the current revision
 	var list []*Pod
 
-	pods, err := r.client.Pods(namespace).List(labels.Everything(), fields.Everything())
+	pods, err := r.client.Pods(namespace).List(r.podLabels, r.podFields)
 	if err != nil {
 		glog.Errorf("Failed to retrieve a list of pods running, error: %s", err)
 		return nil, err
@@ -116,6 +167,7 @@ func (r kubeAPIImpl) Pods(namespace string) ([]*Pod, error) {
 				Labels:      x.Labels,
 				Annotations: x.Annotations,
 				Address:     x.Status.PodIP,
+				Phase:       string(x.Status.Phase),
 			}
 			list = append(list, pod)
 		}
@@ -124,84 +176,161 @@ func (r kubeAPIImpl) Pods(namespace string) ([]*Pod, error) {
 	return list, nil
 }
 
+// Pod retrieves the pod from the namespace regardless of the phase or selectors, nil if it does not exist
+func (r *kubeAPIImpl) Pod(namespace, name string) (*Pod, error) {
+	x, err := r.client.Pods(namespace).Get(name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to retrieve the pod: %s/%s, error: %s", namespace, name, err)
+	}
+
+	return &Pod{
+		ID:          x.Name,
+		Name:        x.Labels["name"],
+		Namespace:   x.Namespace,
+		Labels:      x.Labels,
+		Annotations: x.Annotations,
+		Address:     x.Status.PodIP,
+		Phase:       string(x.Status.Phase),
+	}, nil
+}
+
 //
 // Watch is the main entry-point for the service, we listen out for changes in the
-// nodes, pods and the refresh timer
+// nodes and the pods within the configured namespaces
 func (r *kubeAPIImpl) Watch(updates UpdateEvent) (ShutdownChannel, error) {
-	var err error
-	var nodeCh, podsCh watch.Interface
-
 	// step: create the done channel
 	shutdownCh := make(ShutdownChannel)
-	go func() {
-		// step: wait for the cleanup channel
-		<-shutdownCh
-	}()
 
 	// step: acquire a nodes watch
-	if nodeCh, err = r.createNodesWatch(); err != nil {
-		return nil, err
+	if getConfig().WithNodes {
+		nodeCh, err := r.createNodesWatch()
+		if err != nil {
+			close(shutdownCh)
+			return nil, err
+		}
+		go r.forwardEvents("nodes", nodeEvent, nodeCh, r.createNodesWatch, updates, shutdownCh)
 	}
-	// step: create a channel for pod updates
-	if podsCh, err = r.createPodsWatch(); err != nil {
-		return nil, err
+
+	// step: create a watch for the pods in each of the namespaces
+	if getConfig().WithPods && r.namespaceSelector != nil {
+		if err := r.watchNamespaces(updates, shutdownCh); err != nil {
+			close(shutdownCh)
+			return nil, err
+		}
 	}
+	if getConfig().WithPods && r.namespaceSelector == nil {
+		for _, namespace := range getNamespaces() {
+			namespace := namespace
+			podsCh, err := r.createPodsWatch(namespace)
+			if err != nil {
+				close(shutdownCh)
+				return nil, err
+			}
+			createWatch := func() (watch.Interface, error) {
+				return r.createPodsWatch(namespace)
+			}
+			go r.forwardEvents(fmt.Sprintf("pods, namespace: '%s'", namespace), podEvent, podsCh, createWatch, updates, shutdownCh)
+		}
+	}
+
+	return shutdownCh, nil
+}
+
+// forwardEvents forwards the events from a watch into the updates channel, if the watch is closed
+// by the api, or was never created, we recreate it
+func (r *kubeAPIImpl) forwardEvents(resource string, eventType int, watcher watch.Interface,
+	createWatch func() (watch.Interface, error), updates UpdateEvent, shutdownCh ShutdownChannel) {
 
-	// notes: the main loop to the service; we wait for changes in the nodes,
-	// the pods or the refresh timer
-	go func() {
-		glog.V(10).Infof("Starting the event service loop")
-		for {
-			select {
-			case update := <-nodeCh.ResultChan():
-				// step: we only care about added or removed nodes, not modified
-				if update.Type == watch.Modified {
-					continue
+	glog.V(10).Infof("Starting the event loop for the %s", resource)
+	// check: if the watch could not be created, we retry here
+	if watcher == nil {
+		if watcher = r.recreateWatch(resource, eventType, createWatch, shutdownCh); watcher == nil {
+			return
+		}
+	}
+	for {
+		select {
+		case <-shutdownCh:
+			watcher.Stop()
+			return
+		case update, ok := <-watcher.ResultChan():
+			if !ok {
+				glog.Warningf("the watch on the %s has been closed, recreating the watch", resource)
+				if watcher = r.recreateWatch(resource, eventType, createWatch, shutdownCh); watcher == nil {
+					return
 				}
-				event := newEvent(nodeEvent, update)
-				glog.V(5).Infof("Recieved an update to the nodes: %v", event)
-				updates <- event
-			case update := <-podsCh.ResultChan():
-				event := newEvent(podEvent, update)
-				glog.V(5).Infof("Recieved an update to the pods: %v", event)
-				updates <- event
+				continue
 			}
+			// step: we only care about added or removed nodes, not modified
+			if eventType == nodeEvent && update.Type == watch.Modified {
+				continue
+			}
+			event := newEvent(eventType, update)
+			glog.V(5).Infof("Recieved an update to the %s: %v", resource, event)
+			updates <- event
 		}
-	}()
+	}
+}
 
-	return shutdownCh, nil
+// recreateWatch attempts to recreate the watch, backing off between the attempts; we return nil
+// if a shutdown was requested in the meantime
+func (r *kubeAPIImpl) recreateWatch(resource string, eventType int, createWatch func() (watch.Interface, error), shutdownCh ShutdownChannel) watch.Interface {
+	watchReconnectsMetric.WithLabelValues(eventTypeName(eventType)).Inc()
+	status.setWatchDisconnected(resource)
+	defer status.setWatchConnected(resource)
+
+	backoff := time.Second
+	for {
+		watcher, err := createWatch()
+		if err == nil {
+			return watcher
+		}
+		glog.Errorf("failed to recreate the watch on the %s, retrying in %s, error: %s", resource, backoff, err)
+
+		select {
+		case <-shutdownCh:
+			return nil
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxWatchBackoff {
+			backoff = maxWatchBackoff
+		}
+	}
 }
 
-// createPodsWatch creates a watcher channel for changes on the pods within the configured namespace
-func (r kubeAPIImpl) createPodsWatch() (watch.Interface, error) {
-	glog.V(10).Infof("Creating a watcher for the kubernetes pods")
+// createPodsWatch creates a watcher channel for changes on the pods within the namespace
+func (r *kubeAPIImpl) createPodsWatch(namespace string) (watch.Interface, error) {
+	glog.V(10).Infof("Creating a watcher for the kubernetes pods, namespace: '%s'", namespace)
 	// step: lets retrieve a revision from which to work from
-	list, err := r.client.Pods(api.NamespaceAll).List(labels.Everything(), fields.Everything())
+	list, err := r.client.Pods(namespace).List(r.podLabels, r.podFields)
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve the list of pods, error: %s", err)
+		return nil, fmt.Errorf("failed to retrieve the list of pods, namespace: '%s', error: %s", namespace, err)
 	}
 
-	// step: create a channel for watching the nodes
-	ch, err := r.client.Pods(api.NamespaceAll).Watch(labels.Everything(), fields.Everything(),
+	// step: create a channel for watching the pods
+	ch, err := r.client.Pods(namespace).Watch(r.podLabels, r.podFields,
 		api.ListOptions{ResourceVersion: list.ResourceVersion})
 
 	if err != nil {
-		return nil, fmt.Errorf("unable to create a watch on pods resources, reason: %s", err)
+		return nil, fmt.Errorf("unable to create a watch on pods resources, namespace: '%s', reason: %s", namespace, err)
 	}
 
 	return ch, nil
 }
 
 // createNodesWatch creates a nodes update interface used to watch changes in nodes
-func (r kubeAPIImpl) createNodesWatch() (watch.Interface, error) {
+func (r *kubeAPIImpl) createNodesWatch() (watch.Interface, error) {
 	glog.V(10).Infof("Creating a watcher for the kubernetes nodes")
 	// step: lets retrieve a revision from which to work from
-	list, err := r.client.Nodes().List(labels.Everything(), fields.Everything())
+	list, err := r.client.Nodes().List(r.nodeLabels, r.nodeFields)
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve a list of nodes, error: %s", err)
 	}
 
-	nodeCh, err := r.client.Nodes().Watch(labels.Everything(), fields.Everything(), api.ListOptions{ResourceVersion: list.ResourceVersion})
+	nodeCh, err := r.client.Nodes().Watch(r.nodeLabels, r.nodeFields, api.ListOptions{ResourceVersion: list.ResourceVersion})
 	if err != nil {
 		return nil, fmt.Errorf("unable to create a watch on node resources, reason: %s", err)
 	}
@@ -211,41 +340,75 @@ func (r kubeAPIImpl) createNodesWatch() (watch.Interface, error) {
 
 // newAPIClient creates a new client to speak to the kubernetes api service
 func (r *kubeAPIImpl) newAPIClient() (*unversioned.Client, error) {
-	// step: create the configuration
-	cfg := unversioned.Config{
-		Host:     getURL(),
-		Insecure: config.HTTPInsecure,
-		Version:  config.APIVersion,
+	// step: create the base configuration
+	cfg, tokenFile, err := newClientConfig()
+	if err != nil {
+		return nil, err
 	}
 
-	// check: ensure the token file exists
-	if config.TokenFile != "" {
-		if _, err := os.Stat(config.TokenFile); os.IsNotExist(err) {
-			return nil, fmt.Errorf("the token file: %s does not exist", config.TokenFile)
-		}
+	// step: the options given on the command line or in the configuration file are always taken as overrides
+	if getConfig().isSet("api") || getConfig().isSet("port") || getConfig().isSet("api-protocol") {
+		cfg.Host = getURL()
+	}
+	if cfg.Version == "" || getConfig().isSet("api-version") {
+		cfg.Version = getConfig().APIVersion
+	}
+	if getConfig().isSet("insecure") {
+		cfg.Insecure = getConfig().HTTPInsecure
+	}
 
-		content, err := ioutil.ReadFile(config.TokenFile)
-		if err != nil {
-			return nil, fmt.Errorf("unable to read the token file: %s, error: %s", config.TokenFile, err)
-		}
-		config.Token = string(content)
+	// check: are we using a token file or user token to authenticate?
+	if getConfig().TokenFile != "" {
+		tokenFile = getConfig().TokenFile
+	}
+	if getConfig().Token != "" {
+		tokenFile = ""
+		cfg.BearerToken = getConfig().Token
 	}
 
-	// check: are we using a user token to authenticate?
-	if config.Token != "" {
-		cfg.BearerToken = config.Token
+	// check: are we using basic authentication?
+	if getConfig().Username != "" {
+		tokenFile = ""
+		cfg.BearerToken = ""
+		cfg.Username = getConfig().Username
+		cfg.Password = getConfig().Password
 	}
 
-	// check: are we using a cert to authenticate
-	if config.CaCertFile != "" {
-		cfg.Insecure = false
-		cfg.TLSClientConfig = unversioned.TLSClientConfig{
-			CAFile: config.CaCertFile,
-		}
+	// check: are we using a cert to verify the api
+	if getConfig().CaCertFile != "" {
+		cfg.TLSClientConfig.CAFile = getConfig().CaCertFile
+		cfg.TLSClientConfig.CAData = nil
+	}
+
+	// check: are we using a client certificate to authenticate
+	if getConfig().ClientCertFile != "" {
+		cfg.TLSClientConfig.CertFile = getConfig().ClientCertFile
+		cfg.TLSClientConfig.KeyFile = getConfig().ClientKeyFile
+		cfg.TLSClientConfig.CertData = nil
+		cfg.TLSClientConfig.KeyData = nil
 	}
 
+	if cfg.Insecure && strings.HasPrefix(cfg.Host, "https") {
+		glog.Warningf("**************************************************************************")
+		glog.Warningf("* the certificate of the api: %s is NOT being verified", cfg.Host)
+		glog.Warningf("* this connection is insecure, please use -ca-cert-file in production")
+		glog.Warningf("**************************************************************************")
+	}
+
+	// step: create the transport, we handle the tls and authentication ourselves
+	transport, err := newTransport(cfg, tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Transport = transport
+	cfg.Insecure = false
+	cfg.TLSClientConfig = unversioned.TLSClientConfig{}
+	cfg.BearerToken, cfg.Username, cfg.Password = "", "", ""
+
+	glog.Infof("using the kubernetes api: %s, version: %s", cfg.Host, cfg.Version)
+
 	// step: initialize the client
-	kube, err := unversioned.New(&cfg)
+	kube, err := unversioned.New(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create a kubernetes api client, reason: %s", err)
 	}
@@ -253,7 +416,89 @@ func (r *kubeAPIImpl) newAPIClient() (*unversioned.Client, error) {
 	return kube, nil
 }
 
+// newClientConfig creates the base client configuration, we use the kubeconfig if one has been
+// specified, else the service account if running inside a pod or lastly the command line options.
+// The token file, if the token should be read from one, is also returned
+func newClientConfig() (*unversioned.Config, string, error) {
+	if getConfig().Kubeconfig != "" || getConfig().KubeContext != "" {
+		glog.V(3).Infof("using the kubeconfig: %s, context: %s", getConfig().Kubeconfig, getConfig().KubeContext)
+		cfg, err := kubeconfigClientConfig(getConfig().Kubeconfig, getConfig().KubeContext)
+		return cfg, "", err
+	}
+	if isInCluster(serviceAccountTokenFile) {
+		glog.V(3).Infof("detected we are running inside a pod, using the service account")
+		cfg, err := inClusterClientConfig(serviceAccountTokenFile, serviceAccountCAFile)
+		return cfg, serviceAccountTokenFile, err
+	}
+
+	return &unversioned.Config{
+		Host:     getURL(),
+		Insecure: getConfig().HTTPInsecure,
+	}, "", nil
+}
+
+// kubeconfigClientConfig loads the client configuration from a kubeconfig file, if no filename is
+// given we fall back to the default loading rules, i.e. $KUBECONFIG or ~/.kube/config
+func kubeconfigClientConfig(filename, context string) (*unversioned.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if filename != "" {
+		rules.ExplicitPath = filename
+	}
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: context}
+
+	cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the kubeconfig: %s, error: %s", filename, err)
+	}
+
+	return cfg, nil
+}
+
+// isInCluster checks if we are running inside a pod, i.e. we have the service environment
+// variables and a service account token
+func isInCluster(tokenFile string) bool {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") == "" || os.Getenv("KUBERNETES_SERVICE_PORT") == "" {
+		return false
+	}
+
+	return fileExists(tokenFile)
+}
+
+// inClusterClientConfig creates the client configuration from the service account
+func inClusterClientConfig(tokenFile, caFile string) (*unversioned.Config, error) {
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the service account token: %s, error: %s", tokenFile, err)
+	}
+
+	cfg := &unversioned.Config{
+		Host:        "https://" + net.JoinHostPort(os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")),
+		BearerToken: strings.TrimSpace(string(token)),
+		Insecure:    getConfig().HTTPInsecure,
+	}
+	if fileExists(caFile) {
+		cfg.TLSClientConfig.CAFile = caFile
+	}
+
+	return cfg, nil
+}
+
+// parseSelectors parses the label and field selectors, any environment variables within them are
+// expanded first, i.e. spec.nodeName=$NODE_NAME
+func parseSelectors(labelSelector, fieldSelector string) (labels.Selector, fields.Selector, error) {
+	labelsSelector, err := labels.Parse(os.ExpandEnv(labelSelector))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid label selector: %s, error: %s", labelSelector, err)
+	}
+	fieldsSelector, err := fields.ParseSelector(os.ExpandEnv(fieldSelector))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid field selector: %s, error: %s", fieldSelector, err)
+	}
+
+	return labelsSelector, fieldsSelector, nil
+}
+
 // getURL: generate the url used to communicate with the kubernetes api service
 func getURL() string {
-	return fmt.Sprintf("%s://%s:%d", config.APIProtocol, config.Host, config.Port)
+	return fmt.Sprintf("%s://%s:%d", getConfig().APIProtocol, getConfig().Host, getConfig().Port)
 }
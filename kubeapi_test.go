@@ -17,8 +17,14 @@ limitations under the License.
 package main
 
 import (
+	"io/ioutil"
+	"os"
 	"sync"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/fields"
+	"k8s.io/kubernetes/pkg/labels"
 )
 
 type fakeKubeAPI struct{}
@@ -50,6 +56,10 @@ func (r fakeKubeAPI) NamespaceExists(namespace string) (bool, error) {
 	return false, nil
 }
 
+func (r fakeKubeAPI) Namespaces() ([]string, error) {
+	return getNamespaces(), nil
+}
+
 func (r fakeKubeAPI) Nodes() ([]*Node, error) {
 	return []*Node{
 		{
@@ -84,7 +94,7 @@ func (r fakeKubeAPI) Pods(namespace string) ([]*Pod, error) {
 					"name": "nginx",
 				},
 				Annotations: map[string]string{
-					config.MetricAnnotation: "- name: collectd-exporter\n  port: 9103\n",
+					getConfig().MetricAnnotation: "- name: collectd-exporter\n  port: 9103\n",
 				},
 				Address: "10.10.0.100",
 			},
@@ -96,7 +106,7 @@ func (r fakeKubeAPI) Pods(namespace string) ([]*Pod, error) {
 					"name": "nginx",
 				},
 				Annotations: map[string]string{
-					config.MetricAnnotation: "- name: collectd-exporter\n  port: 9103\n",
+					getConfig().MetricAnnotation: "- name: collectd-exporter\n  port: 9103\n",
 				},
 				Address: "10.10.0.101",
 			},
@@ -108,7 +118,7 @@ func (r fakeKubeAPI) Pods(namespace string) ([]*Pod, error) {
 					"name": "nginx",
 				},
 				Annotations: map[string]string{
-					config.MetricAnnotation: "- name: collectd-exporter\n  port: 9103\n",
+					getConfig().MetricAnnotation: "- name: collectd-exporter\n  port: 9103\n",
 				},
 				Address: "10.10.0.103",
 			},
@@ -122,7 +132,7 @@ func (r fakeKubeAPI) Pods(namespace string) ([]*Pod, error) {
 					"name": "prometheus",
 				},
 				Annotations: map[string]string{
-					config.MetricAnnotation: "- name: prometheus-exporter\n  port: 1000\n",
+					getConfig().MetricAnnotation: "- name: prometheus-exporter\n  port: 1000\n",
 				},
 				Address: "10.10.2.10",
 			},
@@ -134,7 +144,7 @@ func (r fakeKubeAPI) Pods(namespace string) ([]*Pod, error) {
 					"name": "nginx",
 				},
 				Annotations: map[string]string{
-					config.MetricAnnotation: "- name: prometheus-exporter\n  port: 1000\n",
+					getConfig().MetricAnnotation: "- name: prometheus-exporter\n  port: 1000\n",
 				},
 				Address: "10.10.1.4",
 			},
@@ -146,7 +156,7 @@ func (r fakeKubeAPI) Pods(namespace string) ([]*Pod, error) {
 					"name": "prometheus",
 				},
 				Annotations: map[string]string{
-					config.MetricAnnotation: "- name: prometheus-exporter\n  port: 1000\n",
+					getConfig().MetricAnnotation: "- name: prometheus-exporter\n  port: 1000\n",
 				},
 				Address: "10.10.0.13",
 			},
@@ -166,6 +176,94 @@ func (r fakeKubeAPI) Pods(namespace string) ([]*Pod, error) {
 	return list, nil
 }
 
+func (r fakeKubeAPI) Pod(namespace, name string) (*Pod, error) {
+	pods, _ := r.Pods(namespace)
+	for _, pod := range pods {
+		if pod.ID == name {
+			return pod, nil
+		}
+	}
+
+	return nil, nil
+}
+
 func (r fakeKubeAPI) Watch(UpdateEvent) (ShutdownChannel, error) {
 	return nil, nil
 }
+
+func (r fakeKubeAPI) ConfigMap(namespace, name string) (*ConfigMap, error) {
+	return nil, nil
+}
+
+func (r fakeKubeAPI) SaveConfigMap(*ConfigMap) error {
+	return nil
+}
+
+func (r fakeKubeAPI) LeaderLock(kind, namespace, name string) (*LeaderLock, error) {
+	return nil, nil
+}
+
+func (r fakeKubeAPI) SaveLeaderLock(*LeaderLock) error {
+	return nil
+}
+
+func TestIsInCluster(t *testing.T) {
+	token, err := ioutil.TempFile("", "token")
+	assert.Nil(t, err)
+	defer os.Remove(token.Name())
+
+	os.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+	os.Setenv("KUBERNETES_SERVICE_PORT", "443")
+	defer os.Unsetenv("KUBERNETES_SERVICE_HOST")
+	defer os.Unsetenv("KUBERNETES_SERVICE_PORT")
+
+	assert.True(t, isInCluster(token.Name()))
+	assert.False(t, isInCluster("/does/not/exist"))
+	os.Unsetenv("KUBERNETES_SERVICE_HOST")
+	assert.False(t, isInCluster(token.Name()))
+}
+
+func TestInClusterClientConfig(t *testing.T) {
+	token, err := ioutil.TempFile("", "token")
+	assert.Nil(t, err)
+	defer os.Remove(token.Name())
+	token.WriteString("my-token\n")
+	token.Close()
+
+	os.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+	os.Setenv("KUBERNETES_SERVICE_PORT", "443")
+	defer os.Unsetenv("KUBERNETES_SERVICE_HOST")
+	defer os.Unsetenv("KUBERNETES_SERVICE_PORT")
+
+	cfg, err := inClusterClientConfig(token.Name(), token.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, "https://10.0.0.1:443", cfg.Host)
+	assert.Equal(t, "my-token", cfg.BearerToken)
+	assert.Equal(t, token.Name(), cfg.TLSClientConfig.CAFile)
+	assert.False(t, cfg.Insecure)
+
+	_, err = inClusterClientConfig("/does/not/exist", "")
+	assert.NotNil(t, err)
+}
+
+func TestParseSelectors(t *testing.T) {
+	os.Setenv("TEST_NODE_NAME", "node1")
+	defer os.Unsetenv("TEST_NODE_NAME")
+
+	labelSelector, fieldSelector, err := parseSelectors("monitoring=enabled", "spec.nodeName=$TEST_NODE_NAME")
+	assert.Nil(t, err)
+	assert.True(t, labelSelector.Matches(labels.Set{"monitoring": "enabled"}))
+	assert.False(t, labelSelector.Matches(labels.Set{"monitoring": "disabled"}))
+	assert.True(t, fieldSelector.Matches(fields.Set{"spec.nodeName": "node1"}))
+	assert.False(t, fieldSelector.Matches(fields.Set{"spec.nodeName": "node2"}))
+
+	labelSelector, fieldSelector, err = parseSelectors("", "")
+	assert.Nil(t, err)
+	assert.True(t, labelSelector.Empty())
+	assert.True(t, fieldSelector.Empty())
+
+	_, _, err = parseSelectors("name in (a", "")
+	assert.NotNil(t, err)
+	_, _, err = parseSelectors("", "spec.nodeName")
+	assert.NotNil(t, err)
+}
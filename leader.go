@@ -0,0 +1,283 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// the annotation on the lock holding the leader election record
+	leaderAnnotation = "control-plane.alpha.kubernetes.io/leader"
+	// the interval between the attempts to acquire or renew the lease
+	leaderRetryPeriod = 2 * time.Second
+)
+
+// leaderLockKinds maps the resources which can be used as a lock to their kind
+var leaderLockKinds = map[string]string{
+	"configmaps": "ConfigMap",
+	"endpoints":  "Endpoints",
+}
+
+// leaderRecord is the leader election record held in the annotation of the lock
+type leaderRecord struct {
+	// the identity of the leader
+	HolderIdentity string `json:"holderIdentity"`
+	// the duration of the lease in seconds
+	LeaseDurationSeconds int `json:"leaseDurationSeconds"`
+	// the time the leadership was acquired
+	AcquireTime time.Time `json:"acquireTime"`
+	// the time the lease was last renewed
+	RenewTime time.Time `json:"renewTime"`
+	// the number of times the leadership has changed hands
+	LeaderTransitions int `json:"leaderTransitions"`
+}
+
+// leaderElector acquires and renews a lease on the lock; only the leader writes the outputs,
+// the followers keep generating so their caches and debug endpoints stay warm
+type leaderElector struct {
+	sync.RWMutex
+	// the client used to access the lock
+	client KubeAPI
+	// the resource, namespace and name of the lock
+	kind, namespace, name string
+	// the identity of this replica
+	identity string
+	// the duration of the lease
+	leaseDuration time.Duration
+	// the interval between the attempts to acquire or renew
+	retryPeriod time.Duration
+	// returns the current time
+	now func() time.Time
+	// indicates we are the leader
+	leader bool
+	// the record last observed on the lock and the time it was observed, the lease of another
+	// holder is measured from the observation using our own clock
+	observed     string
+	observedTime time.Time
+	// the time we last renewed our lease
+	renewTime time.Time
+	// the channel notified on a change of leadership
+	changesCh chan bool
+}
+
+// parseLeaderLockOption parses the lock option i.e. configmaps/namespace/name
+func parseLeaderLockOption(option string) (string, string, string, error) {
+	items := regexp.MustCompile(`^(configmaps|endpoints)/([-a-z0-9]+)/([-.a-z0-9]+)$`).FindStringSubmatch(option)
+	if items == nil {
+		return "", "", "", fmt.Errorf("invalid leader election lock: %s, should be in the format of configmaps/namespace/name or endpoints/namespace/name", option)
+	}
+
+	return items[1], items[2], items[3], nil
+}
+
+// newLeaderElector creates the leader election from the configuration
+func newLeaderElector(client KubeAPI) (*leaderElector, error) {
+	kind, namespace, name, err := parseLeaderLockOption(getConfig().LeaderElect)
+	if err != nil {
+		return nil, err
+	}
+
+	return &leaderElector{
+		client:        client,
+		kind:          kind,
+		namespace:     namespace,
+		name:          name,
+		identity:      getConfig().LeaderIdentity,
+		leaseDuration: time.Duration(getConfig().LeaderLease) * time.Second,
+		retryPeriod:   leaderRetryPeriod,
+		now:           time.Now,
+		changesCh:     make(chan bool, 1),
+	}, nil
+}
+
+// start makes the first attempt at the leadership and continues in the background
+func (r *leaderElector) start() {
+	glog.Infof("starting the leader election, identity: %s, lock: %s", r.identity, r.lock())
+	leaderMetric.Set(0)
+	r.elect()
+	go r.run()
+}
+
+// run attempts to acquire or renew the lease on every retry period
+func (r *leaderElector) run() {
+	ticker := time.NewTicker(r.retryPeriod)
+	defer ticker.Stop()
+	for range ticker.C {
+		r.elect()
+	}
+}
+
+// elect attempts to acquire or renew the lease and updates the leadership; on an error we remain the
+// leader until the renew deadline, two thirds of the lease, so we step down before anyone can take over
+func (r *leaderElector) elect() {
+	leader, err := r.tryAcquireOrRenew()
+	if err != nil {
+		glog.Warningf("failed to acquire or renew the lease on the lock: %s, error: %s", r.lock(), err)
+		leaderElectionFailuresMetric.Inc()
+		leader = r.isLeader() && r.now().Sub(r.renewTime) < r.leaseDuration*2/3
+	}
+	r.setLeader(leader)
+}
+
+// tryAcquireOrRenew attempts to acquire the lease, or renew it if we are the leader
+func (r *leaderElector) tryAcquireOrRenew() (bool, error) {
+	now := r.now()
+	record := leaderRecord{
+		HolderIdentity:       r.identity,
+		LeaseDurationSeconds: int(r.leaseDuration / time.Second),
+		AcquireTime:          now,
+		RenewTime:            now,
+	}
+
+	lock, err := r.client.LeaderLock(r.kind, r.namespace, r.name)
+	if err != nil {
+		return false, err
+	}
+	if lock == nil {
+		lock = &LeaderLock{Kind: r.kind, Name: r.name, Namespace: r.namespace}
+	} else if content := lock.Annotations[leaderAnnotation]; content != "" {
+		current := leaderRecord{}
+		if err := json.Unmarshal([]byte(content), &current); err != nil {
+			return false, fmt.Errorf("unable to decode the leader record, error: %s", err)
+		}
+		if content != r.observed {
+			if current.HolderIdentity != r.identity {
+				glog.Infof("the leader of the lock: %s is: %s", r.lock(), current.HolderIdentity)
+			}
+			r.observed = content
+			r.observedTime = now
+		}
+		// check: another replica holds an unexpired lease
+		lease := time.Duration(current.LeaseDurationSeconds) * time.Second
+		if current.HolderIdentity != "" && current.HolderIdentity != r.identity && r.observedTime.Add(lease).After(now) {
+			return false, nil
+		}
+		record.LeaderTransitions = current.LeaderTransitions
+		if current.HolderIdentity == r.identity {
+			record.AcquireTime = current.AcquireTime
+		} else {
+			record.LeaderTransitions++
+		}
+	}
+
+	content, err := json.Marshal(record)
+	if err != nil {
+		return false, err
+	}
+	if lock.Annotations == nil {
+		lock.Annotations = make(map[string]string, 0)
+	}
+	lock.Annotations[leaderAnnotation] = string(content)
+	if err := r.client.SaveLeaderLock(lock); err != nil {
+		return false, err
+	}
+	r.observed = string(content)
+	r.observedTime = now
+	r.renewTime = now
+
+	return true, nil
+}
+
+// setLeader updates the leadership, logging and notifying any change
+func (r *leaderElector) setLeader(leader bool) {
+	r.Lock()
+	changed := r.leader != leader
+	r.leader = leader
+	r.Unlock()
+	if !changed {
+		return
+	}
+
+	if leader {
+		glog.Infof("acquired the leadership of the lock: %s, identity: %s", r.lock(), r.identity)
+		leaderMetric.Set(1)
+	} else {
+		glog.Warningf("lost the leadership of the lock: %s, identity: %s", r.lock(), r.identity)
+		leaderMetric.Set(0)
+	}
+	leaderTransitionsMetric.Inc()
+
+	select {
+	case r.changesCh <- true:
+	default:
+	}
+}
+
+// isLeader checks if we are the leader
+func (r *leaderElector) isLeader() bool {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.leader
+}
+
+// changes returns the channel notified on a change of leadership, nil if leader election is disabled
+func (r *leaderElector) changes() chan bool {
+	if r == nil {
+		return nil
+	}
+
+	return r.changesCh
+}
+
+// lock returns the location of the lock
+func (r *leaderElector) lock() string {
+	return fmt.Sprintf("%s/%s/%s", r.kind, r.namespace, r.name)
+}
+
+// leaderSink only writes to the shared sink while we are the leader
+type leaderSink struct {
+	// the sink the leader writes to
+	sink outputSink
+	// the leader election
+	elector *leaderElector
+}
+
+// write writes the file if we are the leader
+func (r *leaderSink) write(filename string, content []byte) (bool, error) {
+	if !r.elector.isLeader() {
+		glog.V(4).Infof("skipping the write of the file: %s, we are not the leader", filename)
+		return false, nil
+	}
+
+	return r.sink.write(filename, content)
+}
+
+// list lists the files if we are the leader, followers have nothing to remove
+func (r *leaderSink) list() ([]string, error) {
+	if !r.elector.isLeader() {
+		return nil, nil
+	}
+
+	return r.sink.list()
+}
+
+// remove removes the file if we are the leader
+func (r *leaderSink) remove(filename string) error {
+	if !r.elector.isLeader() {
+		return nil
+	}
+
+	return r.sink.remove(filename)
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/api/errors"
+)
+
+// fakeLockAPI stores the leader locks in memory
+type fakeLockAPI struct {
+	fakeKubeAPI
+	// the locks keyed by kind/namespace/name
+	items map[string]*LeaderLock
+	// the revision of the locks
+	revision int
+	// fail all the requests
+	failing bool
+}
+
+func newFakeLockAPI() *fakeLockAPI {
+	return &fakeLockAPI{items: make(map[string]*LeaderLock, 0)}
+}
+
+func (r *fakeLockAPI) LeaderLock(kind, namespace, name string) (*LeaderLock, error) {
+	if r.failing {
+		return nil, fmt.Errorf("the api is unavailable")
+	}
+	lock, found := r.items[kind+"/"+namespace+"/"+name]
+	if !found {
+		return nil, nil
+	}
+	copied := *lock
+	copied.Annotations = make(map[string]string, 0)
+	for k, v := range lock.Annotations {
+		copied.Annotations[k] = v
+	}
+
+	return &copied, nil
+}
+
+func (r *fakeLockAPI) SaveLeaderLock(lock *LeaderLock) error {
+	if r.failing {
+		return fmt.Errorf("the api is unavailable")
+	}
+	key := lock.Kind + "/" + lock.Namespace + "/" + lock.Name
+	if current, found := r.items[key]; found && current.ResourceVersion != lock.ResourceVersion {
+		return errors.NewConflict(lock.Kind, lock.Name, nil)
+	}
+	r.revision++
+	saved := *lock
+	saved.ResourceVersion = strconv.Itoa(r.revision)
+	r.items[key] = &saved
+
+	return nil
+}
+
+func (r *fakeLockAPI) record(t *testing.T) leaderRecord {
+	record := leaderRecord{}
+	lock := r.items["configmaps/kube-system/prometheus-k8s"]
+	if assert.NotNil(t, lock) {
+		assert.Nil(t, json.Unmarshal([]byte(lock.Annotations[leaderAnnotation]), &record))
+	}
+
+	return record
+}
+
+func newTestLeaderElector(client KubeAPI, identity string, clock *time.Time) *leaderElector {
+	return &leaderElector{
+		client:        client,
+		kind:          "configmaps",
+		namespace:     "kube-system",
+		name:          "prometheus-k8s",
+		identity:      identity,
+		leaseDuration: 15 * time.Second,
+		retryPeriod:   leaderRetryPeriod,
+		now:           func() time.Time { return *clock },
+		changesCh:     make(chan bool, 1),
+	}
+}
+
+func TestParseLeaderLockOption(t *testing.T) {
+	kind, namespace, name, err := parseLeaderLockOption("endpoints/kube-system/prometheus-k8s")
+	assert.Nil(t, err)
+	assert.Equal(t, "endpoints", kind)
+	assert.Equal(t, "kube-system", namespace)
+	assert.Equal(t, "prometheus-k8s", name)
+
+	for _, option := range []string{"", "kube-system/prometheus-k8s", "secrets/kube-system/lock", "configmaps/kube-system/"} {
+		_, _, _, err := parseLeaderLockOption(option)
+		assert.NotNil(t, err, "option: %s should be invalid", option)
+	}
+}
+
+func TestLeaderElection(t *testing.T) {
+	api := newFakeLockAPI()
+	clock := time.Now()
+	first := newTestLeaderElector(api, "replica-a", &clock)
+	second := newTestLeaderElector(api, "replica-b", &clock)
+
+	first.elect()
+	second.elect()
+	assert.True(t, first.isLeader())
+	assert.False(t, second.isLeader())
+	assert.Equal(t, "replica-a", api.record(t).HolderIdentity)
+	assert.Len(t, first.changes(), 1)
+	assert.Len(t, second.changes(), 0)
+
+	// step: the leader renews its lease, the follower keeps waiting
+	clock = clock.Add(10 * time.Second)
+	first.elect()
+	clock = clock.Add(10 * time.Second)
+	second.elect()
+	assert.True(t, first.isLeader())
+	assert.False(t, second.isLeader())
+
+	// step: the leader stops renewing and the follower takes over once the lease expires
+	clock = clock.Add(16 * time.Second)
+	second.elect()
+	assert.True(t, second.isLeader())
+	record := api.record(t)
+	assert.Equal(t, "replica-b", record.HolderIdentity)
+	assert.Equal(t, 1, record.LeaderTransitions)
+
+	first.elect()
+	assert.False(t, first.isLeader())
+	assert.Equal(t, "replica-b", api.record(t).HolderIdentity)
+}
+
+func TestLeaderElectionRenewFailure(t *testing.T) {
+	api := newFakeLockAPI()
+	clock := time.Now()
+	elector := newTestLeaderElector(api, "replica-a", &clock)
+	elector.elect()
+	assert.True(t, elector.isLeader())
+
+	// step: we remain the leader until the renew deadline
+	api.failing = true
+	clock = clock.Add(5 * time.Second)
+	elector.elect()
+	assert.True(t, elector.isLeader())
+	clock = clock.Add(6 * time.Second)
+	elector.elect()
+	assert.False(t, elector.isLeader())
+}
+
+func TestLeaderNewSink(t *testing.T) {
+	defer func(configmap string) { getConfig().ConfigMap = configmap }(getConfig().ConfigMap)
+	clock := time.Now()
+	service := newTestPrometheusK8S(t)
+	service.elector = newTestLeaderElector(newFakeLockAPI(), "replica-a", &clock)
+
+	// check: the local files are written by every replica
+	getConfig().ConfigMap = ""
+	_, isFiles := service.newSink(service.client).(*fileSink)
+	assert.True(t, isFiles)
+
+	// check: the shared configmap is only written by the leader
+	getConfig().ConfigMap = "monitoring/prometheus-targets"
+	_, isLeader := service.newSink(service.client).(*leaderSink)
+	assert.True(t, isLeader)
+}
+
+func TestLeaderSink(t *testing.T) {
+	clock := time.Now()
+	elector := newTestLeaderElector(newFakeLockAPI(), "replica-a", &clock)
+	files := newFakeSink()
+	files.files["pods-stale.yml"] = []byte("[]")
+	sink := &leaderSink{sink: files, elector: elector}
+
+	changed, err := sink.write("pods.yml", []byte("[]"))
+	assert.Nil(t, err)
+	assert.False(t, changed)
+	list, err := sink.list()
+	assert.Nil(t, err)
+	assert.Empty(t, list)
+	assert.Nil(t, sink.remove("pods-stale.yml"))
+	assert.Len(t, files.files, 1)
+
+	elector.elect()
+	changed, err = sink.write("pods.yml", []byte("[]"))
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	assert.Nil(t, sink.remove("pods-stale.yml"))
+	list, _ = files.list()
+	assert.Equal(t, []string{"pods.yml"}, list)
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/kubernetes/pkg/api/errors"
+)
+
+// LeaderLock retrieves the object used as the leader election lock, we return nil if it does not exist
+func (r *kubeAPIImpl) LeaderLock(kind, namespace, name string) (*LeaderLock, error) {
+	content, err := r.client.Get().Namespace(namespace).Resource(kind).Name(name).Do().Raw()
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to retrieve the lock: %s/%s/%s, error: %s", kind, namespace, name, err)
+	}
+
+	var object map[string]interface{}
+	if err := json.Unmarshal(content, &object); err != nil {
+		return nil, fmt.Errorf("failed to decode the lock: %s/%s/%s, error: %s", kind, namespace, name, err)
+	}
+
+	lock := &LeaderLock{
+		Kind:        kind,
+		Name:        name,
+		Namespace:   namespace,
+		Annotations: make(map[string]string, 0),
+		object:      object,
+	}
+	if metadata, found := object["metadata"].(map[string]interface{}); found {
+		lock.ResourceVersion, _ = metadata["resourceVersion"].(string)
+		if annotations, found := metadata["annotations"].(map[string]interface{}); found {
+			for key, value := range annotations {
+				if text, found := value.(string); found {
+					lock.Annotations[key] = text
+				}
+			}
+		}
+	}
+
+	return lock, nil
+}
+
+// SaveLeaderLock creates the lock, or updates it if it has a resource version
+func (r *kubeAPIImpl) SaveLeaderLock(lock *LeaderLock) error {
+	object := lock.object
+	if object == nil {
+		object = map[string]interface{}{
+			"kind":       leaderLockKinds[lock.Kind],
+			"apiVersion": "v1",
+		}
+	}
+	metadata, found := object["metadata"].(map[string]interface{})
+	if !found {
+		metadata = make(map[string]interface{}, 0)
+	}
+	metadata["name"] = lock.Name
+	metadata["namespace"] = lock.Namespace
+	metadata["annotations"] = lock.Annotations
+	if lock.ResourceVersion != "" {
+		metadata["resourceVersion"] = lock.ResourceVersion
+	}
+	object["metadata"] = metadata
+
+	content, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+
+	if lock.ResourceVersion == "" {
+		err = r.client.Post().Namespace(lock.Namespace).Resource(lock.Kind).
+			SetHeader("Content-Type", "application/json").Body(content).Do().Error()
+	} else {
+		err = r.client.Put().Namespace(lock.Namespace).Resource(lock.Kind).Name(lock.Name).
+			SetHeader("Content-Type", "application/json").Body(content).Do().Error()
+	}
+
+	return err
+}
@@ -25,6 +25,18 @@ import (
 )
 
 func main() {
+	// step: check for any of the subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(validateCommand(os.Args[2:], os.Stdout))
+		case "generate":
+			os.Exit(generateCommand(os.Args[2:]))
+		case "explain":
+			os.Exit(explainCommand(os.Args[2:], os.Stdout))
+		}
+	}
+
 	// step: parse the command line configuration
 	if err := parseConfig(); err != nil {
 		glog.Errorf("invalid configuration, error: %s", err)
@@ -38,9 +50,32 @@ func main() {
 		os.Exit(1)
 	}
 
+	// step: in the diff mode we generate once and exit
+	if getConfig().Diff {
+		os.Exit(diffCommand(service))
+	}
+
+	// step: start the http service
+	if getConfig().ListenAddress != "" {
+		if err := service.startHTTPServer(); err != nil {
+			glog.Errorf("failed to start the http service, error: %s", err)
+			os.Exit(1)
+		}
+	}
+
 	// step: create a exit channel
 	signalChannel := make(chan os.Signal)
-	signal.Notify(signalChannel, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	signal.Notify(signalChannel, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+
+	// step: reload the configuration on a SIGHUP
+	reloadChannel := make(chan os.Signal, 1)
+	signal.Notify(reloadChannel, syscall.SIGHUP)
+	go func() {
+		for range reloadChannel {
+			glog.Infof("received a SIGHUP, reloading the configuration")
+			service.requestConfigReload()
+		}
+	}()
 
 	// step: generate the onetime config
 	err = service.GenerateConfiguration()
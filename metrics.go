@@ -0,0 +1,176 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// the namespace for all the metrics we export
+	metricsNamespace = "prometheus_k8s"
+)
+
+var (
+	// the events received from the watches, by resource and event type
+	eventsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_total",
+		Help:      "The number of events received from kubernetes, by resource and type",
+	}, []string{"resource", "type"})
+	// the number of times we have generated the configuration
+	generationsMetric = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "generations_total",
+		Help:      "The number of times the configuration has been generated",
+	})
+	// the number of times the generation has failed
+	generationFailuresMetric = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "generation_failures_total",
+		Help:      "The number of times the generation of the configuration has failed",
+	})
+	// the time taken to generate the configuration
+	generationDurationMetric = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "generation_duration_seconds",
+		Help:      "The time taken to generate the configuration",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	})
+	// the time of the last successful generation
+	lastGenerationMetric = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_successful_generation_timestamp_seconds",
+		Help:      "The unix timestamp of the last successful generation of the configuration",
+	})
+	// the number of writes of the configuration files
+	fileWritesMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "file_writes_total",
+		Help:      "The number of times a configuration file has been written",
+	}, []string{"file"})
+	// the number of failed writes of the configuration files
+	fileWriteErrorsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "file_write_errors_total",
+		Help:      "The number of times writing a configuration file has failed",
+	}, []string{"file"})
+	// the number of metrics annotations we could not decode
+	decodeFailuresMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "annotation_decode_failures_total",
+		Help:      "The number of pod metrics annotations which could not be decoded, by namespace",
+	}, []string{"namespace"})
+	// the number of targets in each of the files
+	targetsMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "targets",
+		Help:      "The number of targets within each of the configuration files",
+	}, []string{"file"})
+	// the number of attempts to reload prometheus
+	reloadsMetric = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reloads_total",
+		Help:      "The number of attempts to reload prometheus",
+	})
+	// the number of failed attempts to reload prometheus
+	reloadFailuresMetric = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reload_failures_total",
+		Help:      "The number of failed attempts to reload prometheus",
+	})
+	// the number of successful reloads of the service configuration
+	configReloadsMetric = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "config_reloads_total",
+		Help:      "The number of successful reloads of the service configuration",
+	})
+	// the number of rejected reloads of the service configuration
+	configReloadFailuresMetric = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "config_reload_failures_total",
+		Help:      "The number of rejected reloads of the service configuration",
+	})
+	// the number of metrics entries whose alerts were skipped as invalid
+	alertFailuresMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "alert_failures_total",
+		Help:      "The number of metrics entries whose alerts were skipped as invalid",
+	}, []string{"namespace"})
+	// the number of targets assigned to each shard
+	shardTargetsMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "shard_targets",
+		Help:      "The number of targets assigned to each shard",
+	}, []string{"shard"})
+	// indicates if this replica is the leader
+	leaderMetric = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "leader",
+		Help:      "Indicates if this replica is the leader and writes the outputs, 1 if so",
+	})
+	// the number of changes in leadership of this replica
+	leaderTransitionsMetric = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "leader_transitions_total",
+		Help:      "The number of times this replica has acquired or lost the leadership",
+	})
+	// the number of failed attempts to acquire or renew the lease
+	leaderElectionFailuresMetric = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "leader_election_failures_total",
+		Help:      "The number of failed attempts to acquire or renew the leader lease",
+	})
+	// the number of times we have had to recreate a watch
+	watchReconnectsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "watch_reconnects_total",
+		Help:      "The number of times a watch on kubernetes had to be recreated, by resource",
+	}, []string{"resource"})
+)
+
+func init() {
+	prometheus.MustRegister(eventsMetric)
+	prometheus.MustRegister(generationsMetric)
+	prometheus.MustRegister(generationFailuresMetric)
+	prometheus.MustRegister(generationDurationMetric)
+	prometheus.MustRegister(lastGenerationMetric)
+	prometheus.MustRegister(fileWritesMetric)
+	prometheus.MustRegister(fileWriteErrorsMetric)
+	prometheus.MustRegister(decodeFailuresMetric)
+	prometheus.MustRegister(targetsMetric)
+	prometheus.MustRegister(watchReconnectsMetric)
+	prometheus.MustRegister(reloadsMetric)
+	prometheus.MustRegister(reloadFailuresMetric)
+	prometheus.MustRegister(configReloadsMetric)
+	prometheus.MustRegister(configReloadFailuresMetric)
+	prometheus.MustRegister(alertFailuresMetric)
+	prometheus.MustRegister(shardTargetsMetric)
+	prometheus.MustRegister(leaderMetric)
+	prometheus.MustRegister(leaderTransitionsMetric)
+	prometheus.MustRegister(leaderElectionFailuresMetric)
+}
+
+// countTargets returns the total number of targets in the groups
+func countTargets(groups []*Targets) int {
+	count := 0
+	for _, group := range groups {
+		count += len(group.Targets)
+	}
+
+	return count
+}
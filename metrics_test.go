@@ -0,0 +1,31 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountTargets(t *testing.T) {
+	assert.Equal(t, 0, countTargets(nil))
+	assert.Equal(t, 3, countTargets([]*Targets{
+		{Targets: []string{"10.0.0.1:80", "10.0.0.2:80"}},
+		{Targets: []string{"10.0.0.3:80"}},
+	}))
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/fields"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+// namespaceSet is the set of namespaces selected by the namespace selector, along with
+// the shutdown channel for the pods watch within each of them
+type namespaceSet struct {
+	sync.RWMutex
+	// the namespaces and the shutdown channel of their pods watch
+	items map[string]ShutdownChannel
+	// indicates the set has been populated from the api
+	populated bool
+}
+
+// newNamespaceSet creates a new empty set of namespaces
+func newNamespaceSet() *namespaceSet {
+	return &namespaceSet{
+		items: make(map[string]ShutdownChannel, 0),
+	}
+}
+
+// synced checks if the set has been populated from the api
+func (r *namespaceSet) synced() bool {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.populated
+}
+
+// has checks if the namespace is in the set
+func (r *namespaceSet) has(namespace string) bool {
+	r.RLock()
+	defer r.RUnlock()
+	_, found := r.items[namespace]
+
+	return found
+}
+
+// list returns a sorted list of the namespaces
+func (r *namespaceSet) list() []string {
+	r.RLock()
+	defer r.RUnlock()
+
+	list := make([]string, 0, len(r.items))
+	for name := range r.items {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+
+	return list
+}
+
+// add adds the namespace to the set
+func (r *namespaceSet) add(namespace string, shutdownCh ShutdownChannel) {
+	r.Lock()
+	defer r.Unlock()
+	r.items[namespace] = shutdownCh
+}
+
+// remove removes the namespace from the set, returning the shutdown channel for it
+func (r *namespaceSet) remove(namespace string) (ShutdownChannel, bool) {
+	r.Lock()
+	defer r.Unlock()
+	shutdownCh, found := r.items[namespace]
+	delete(r.items, namespace)
+
+	return shutdownCh, found
+}
+
+// markSynced indicates the set has been populated
+func (r *namespaceSet) markSynced() {
+	r.Lock()
+	defer r.Unlock()
+	r.populated = true
+}
+
+// Namespaces retrieves the list of namespaces we should generate the pods for, either the
+// static list from the command line or those selected by the namespace selector
+func (r *kubeAPIImpl) Namespaces() ([]string, error) {
+	if r.namespaceSelector == nil {
+		return getNamespaces(), nil
+	}
+	// check: if we are watching the namespaces we can use the set
+	if r.namespaces.synced() {
+		return r.namespaces.list(), nil
+	}
+
+	namespaces, err := r.client.Namespaces().List(r.namespaceSelector, fields.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve the list of namespaces, error: %s", err)
+	}
+	var list []string
+	for _, namespace := range namespaces.Items {
+		list = append(list, namespace.Name)
+	}
+	sort.Strings(list)
+
+	return list, nil
+}
+
+// watchNamespaces starts a pods watch in each of the namespaces selected and watches the namespaces
+// for changes, starting and stopping the pods watches as namespaces are added, labelled or deleted
+func (r *kubeAPIImpl) watchNamespaces(updates UpdateEvent, shutdownCh ShutdownChannel) error {
+	createWatch := func() (watch.Interface, error) {
+		return r.createNamespacesWatch(updates)
+	}
+
+	watcher, err := createWatch()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		glog.V(10).Infof("Starting the event loop for the namespaces, selector: %s", r.namespaceSelector)
+		for {
+			select {
+			case <-shutdownCh:
+				watcher.Stop()
+				for _, namespace := range r.namespaces.list() {
+					r.removeNamespace(namespace)
+				}
+				return
+			case update, ok := <-watcher.ResultChan():
+				if !ok {
+					glog.Warningf("the watch on the namespaces has been closed, recreating the watch")
+					if watcher = r.recreateWatch("namespaces", namespaceEvent, createWatch, shutdownCh); watcher == nil {
+						return
+					}
+					continue
+				}
+				namespace, found := update.Object.(*api.Namespace)
+				if !found {
+					continue
+				}
+
+				// step: we treat a namespace which no longer matches the selector as deleted
+				changed := false
+				if update.Type == watch.Deleted || !r.namespaceSelector.Matches(labels.Set(namespace.Labels)) {
+					changed = r.removeNamespace(namespace.Name)
+				} else {
+					changed = r.addNamespace(namespace.Name, updates)
+				}
+				if changed {
+					event := newEvent(namespaceEvent, update)
+					glog.V(5).Infof("Recieved an update to the namespaces: %v", event)
+					updates <- event
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// createNamespacesWatch synchronizes the selected namespaces with the api and creates a watch on
+// the namespaces from that revision
+func (r *kubeAPIImpl) createNamespacesWatch(updates UpdateEvent) (watch.Interface, error) {
+	glog.V(10).Infof("Creating a watcher for the kubernetes namespaces")
+	// step: lets retrieve a revision from which to work from
+	list, err := r.client.Namespaces().List(r.namespaceSelector, fields.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve the list of namespaces, error: %s", err)
+	}
+
+	// step: synchronize the namespaces we have with the api
+	selected := make(map[string]bool, 0)
+	for _, namespace := range list.Items {
+		selected[namespace.Name] = true
+		r.addNamespace(namespace.Name, updates)
+	}
+	for _, namespace := range r.namespaces.list() {
+		if !selected[namespace] {
+			r.removeNamespace(namespace)
+		}
+	}
+	r.namespaces.markSynced()
+
+	ch, err := r.client.Namespaces().Watch(r.namespaceSelector, fields.Everything(), api.ListOptions{ResourceVersion: list.ResourceVersion})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create a watch on namespace resources, reason: %s", err)
+	}
+
+	return ch, nil
+}
+
+// addNamespace adds the namespace to the set and starts watching the pods within it, we return
+// false if the namespace was already selected
+func (r *kubeAPIImpl) addNamespace(namespace string, updates UpdateEvent) bool {
+	if r.namespaces.has(namespace) {
+		return false
+	}
+	glog.Infof("the namespace: %s has been selected, watching the pods within", namespace)
+
+	// step: create the pods watch, if this fails the event loop will retry
+	podsCh, err := r.createPodsWatch(namespace)
+	if err != nil {
+		glog.Errorf("failed to create the pods watch, namespace: %s, error: %s", namespace, err)
+	}
+	createWatch := func() (watch.Interface, error) {
+		return r.createPodsWatch(namespace)
+	}
+	shutdownCh := make(ShutdownChannel)
+	r.namespaces.add(namespace, shutdownCh)
+	go r.forwardEvents(fmt.Sprintf("pods, namespace: '%s'", namespace), podEvent, podsCh, createWatch, updates, shutdownCh)
+
+	return true
+}
+
+// removeNamespace removes the namespace from the set and stops the pods watch within it, we return
+// false if the namespace was not selected
+func (r *kubeAPIImpl) removeNamespace(namespace string) bool {
+	shutdownCh, found := r.namespaces.remove(namespace)
+	if !found {
+		return false
+	}
+	glog.Infof("the namespace: %s is no longer selected, removing the pods watch", namespace)
+	close(shutdownCh)
+
+	return true
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespaceSet(t *testing.T) {
+	set := newNamespaceSet()
+	assert.False(t, set.synced())
+	assert.Empty(t, set.list())
+
+	set.add("platform", make(ShutdownChannel))
+	set.add("default", make(ShutdownChannel))
+	set.markSynced()
+	assert.True(t, set.synced())
+	assert.True(t, set.has("default"))
+	assert.False(t, set.has("kube-system"))
+	assert.Equal(t, []string{"default", "platform"}, set.list())
+
+	shutdownCh, found := set.remove("default")
+	assert.True(t, found)
+	assert.NotNil(t, shutdownCh)
+	_, found = set.remove("default")
+	assert.False(t, found)
+	assert.Equal(t, []string{"platform"}, set.list())
+}
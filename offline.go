@@ -0,0 +1,307 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"gopkg.in/yaml.v2"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/fields"
+	"k8s.io/kubernetes/pkg/labels"
+)
+
+// offlineKubeAPI is a KubeAPI backed by the objects within manifest files, such as the output of
+// kubectl get pods -o yaml, used to generate the configuration without a cluster
+type offlineKubeAPI struct {
+	// the namespaces and their labels
+	namespaces map[string]map[string]string
+	// the nodes and the fields used for the selectors
+	nodes []*manifestObject
+	// the pods
+	pods []*manifestObject
+	// the configmaps written by the sink
+	configMaps map[string]*ConfigMap
+}
+
+// newOfflineKubeAPI loads the objects from the manifest files and directories
+func newOfflineKubeAPI(paths []string) (*offlineKubeAPI, error) {
+	r := &offlineKubeAPI{
+		namespaces: make(map[string]map[string]string, 0),
+		configMaps: make(map[string]*ConfigMap, 0),
+	}
+
+	var files []string
+	for _, path := range paths {
+		found, err := findManifests(path, true)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, found...)
+	}
+	sort.Strings(files)
+
+	for _, filename := range files {
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		for _, document := range splitDocuments(content) {
+			object := new(manifestObject)
+			if err := yaml.Unmarshal(document.content, object); err != nil {
+				return nil, fmt.Errorf("unable to decode the document at %s:%d, error: %s", filename, document.line, err)
+			}
+			r.add(object)
+		}
+	}
+	glog.V(3).Infof("loaded %d namespaces, %d nodes and %d pods from the manifests", len(r.namespaces), len(r.nodes), len(r.pods))
+
+	return r, nil
+}
+
+// add adds the object to the api, any kinds we do not use are ignored
+func (r *offlineKubeAPI) add(object *manifestObject) {
+	switch object.Kind {
+	case "List", "PodList", "NodeList", "NamespaceList":
+		for _, item := range object.Items {
+			// step: the items of a typed list do not carry the kind
+			if item.Kind == "" && object.Kind != "List" {
+				item.Kind = object.Kind[:len(object.Kind)-len("List")]
+			}
+			r.add(item)
+		}
+	case "Namespace":
+		r.namespaces[object.Metadata.Name] = object.Metadata.Labels
+	case "Node":
+		r.nodes = append(r.nodes, object)
+	case "Pod":
+		if object.Metadata.Namespace == "" {
+			object.Metadata.Namespace = api.NamespaceDefault
+		}
+		if _, found := r.namespaces[object.Metadata.Namespace]; !found {
+			r.namespaces[object.Metadata.Namespace] = nil
+		}
+		r.pods = append(r.pods, object)
+	}
+}
+
+// NamespaceExists checks to see if the namespace is in the manifests
+func (r *offlineKubeAPI) NamespaceExists(namespace string) (bool, error) {
+	if namespace == api.NamespaceAll {
+		return true, nil
+	}
+	_, found := r.namespaces[namespace]
+
+	return found, nil
+}
+
+// Namespaces retrieves the list of namespaces we should generate the pods for
+func (r *offlineKubeAPI) Namespaces() ([]string, error) {
+	if getConfig().NamespaceSelector == "" {
+		return getNamespaces(), nil
+	}
+	selector, err := labels.Parse(getConfig().NamespaceSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespace selector: %s, error: %s", getConfig().NamespaceSelector, err)
+	}
+
+	var list []string
+	for name, namespaceLabels := range r.namespaces {
+		if selector.Matches(labels.Set(namespaceLabels)) {
+			list = append(list, name)
+		}
+	}
+	sort.Strings(list)
+
+	return list, nil
+}
+
+// Nodes retrieves the nodes from the manifests which match the selectors
+func (r *offlineKubeAPI) Nodes() ([]*Node, error) {
+	labelSelector, fieldSelector, err := parseSelectors(getConfig().NodeLabelSelector, getConfig().NodeFieldSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*Node
+	for _, x := range r.nodes {
+		if !labelSelector.Matches(labels.Set(x.Metadata.Labels)) || !fieldSelector.Matches(fields.Set{"metadata.name": x.Metadata.Name}) {
+			continue
+		}
+		list = append(list, &Node{ID: x.Metadata.Name, Labels: x.Metadata.Labels})
+	}
+
+	return list, nil
+}
+
+// Pods retrieves the running pods within the namespace from the manifests which match the selectors
+func (r *offlineKubeAPI) Pods(namespace string) ([]*Pod, error) {
+	labelSelector, fieldSelector, err := parseSelectors(getConfig().PodLabelSelector, getConfig().PodFieldSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*Pod
+	for _, x := range r.pods {
+		if namespace != api.NamespaceAll && x.Metadata.Namespace != namespace {
+			continue
+		}
+		podFields := fields.Set{
+			"metadata.name":      x.Metadata.Name,
+			"metadata.namespace": x.Metadata.Namespace,
+			"spec.nodeName":      x.Spec.NodeName,
+			"status.phase":       x.Status.Phase,
+		}
+		if !labelSelector.Matches(labels.Set(x.Metadata.Labels)) || !fieldSelector.Matches(podFields) {
+			continue
+		}
+		// step: as with the api, we only take the pods which are running
+		if x.Status.Phase != string(api.PodRunning) {
+			glog.V(4).Infof("skipping the pod: %s/%s as it is not running", x.Metadata.Namespace, x.Metadata.Name)
+			continue
+		}
+		list = append(list, newManifestPod(x))
+	}
+
+	return list, nil
+}
+
+// Pod retrieves the pod from the manifests regardless of the phase or selectors
+func (r *offlineKubeAPI) Pod(namespace, name string) (*Pod, error) {
+	for _, x := range r.pods {
+		if x.Metadata.Namespace == namespace && x.Metadata.Name == name {
+			return newManifestPod(x), nil
+		}
+	}
+
+	return nil, nil
+}
+
+// newManifestPod normalizes the pod from the manifest
+func newManifestPod(x *manifestObject) *Pod {
+	return &Pod{
+		ID:          x.Metadata.Name,
+		Name:        x.Metadata.Labels["name"],
+		Namespace:   x.Metadata.Namespace,
+		Labels:      x.Metadata.Labels,
+		Annotations: x.Metadata.Annotations,
+		Address:     x.Status.PodIP,
+		Phase:       x.Status.Phase,
+	}
+}
+
+// Watch does nothing, the manifests do not change
+func (r *offlineKubeAPI) Watch(UpdateEvent) (ShutdownChannel, error) {
+	return make(ShutdownChannel), nil
+}
+
+// ConfigMap retrieves the configmap written by the sink
+func (r *offlineKubeAPI) ConfigMap(namespace, name string) (*ConfigMap, error) {
+	cm, found := r.configMaps[namespace+"/"+name]
+	if !found {
+		return nil, nil
+	}
+
+	return cm, nil
+}
+
+// SaveConfigMap keeps the configmap in memory
+func (r *offlineKubeAPI) SaveConfigMap(cm *ConfigMap) error {
+	r.configMaps[cm.Namespace+"/"+cm.Name] = cm
+
+	return nil
+}
+
+// LeaderLock returns nothing, leader election is not used offline
+func (r *offlineKubeAPI) LeaderLock(kind, namespace, name string) (*LeaderLock, error) {
+	return nil, nil
+}
+
+// SaveLeaderLock fails, leader election is not used offline
+func (r *offlineKubeAPI) SaveLeaderLock(*LeaderLock) error {
+	return fmt.Errorf("leader election is not supported when generating from manifests")
+}
+
+// validateGenerateConfig rejects the options which make no sense when generating from the manifests;
+// the configmap would only be written into memory and a reload would signal a real prometheus
+func validateGenerateConfig(c *Config) error {
+	var options []string
+	for name, value := range map[string]string{
+		"configmap":       c.ConfigMap,
+		"reload-url":      c.ReloadURL,
+		"reload-pid-file": c.ReloadPidFile,
+		"reload-process":  c.ReloadProcess,
+	} {
+		if value != "" {
+			options = append(options, "-"+name)
+		}
+	}
+	if len(options) > 0 {
+		sort.Strings(options)
+		return fmt.Errorf("the options: %s cannot be used when generating from the manifests", strings.Join(options, ", "))
+	}
+
+	return nil
+}
+
+// generateCommand is the generate subcommand, it generates the configuration from the manifests
+// rather than a cluster and returns the exit code
+func generateCommand(args []string) int {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s generate [options] <file or directory>...\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	if err := parseConfigArgs(args); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration, error: %s\n", err)
+		return 2
+	}
+	if err := validateGenerateConfig(getConfig()); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration, error: %s\n", err)
+		return 2
+	}
+	if flag.NArg() == 0 {
+		flag.Usage()
+		return 2
+	}
+
+	client, err := newOfflineKubeAPI(flag.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load the manifests, error: %s\n", err)
+		return 1
+	}
+	service, err := newPrometheusK8S(client)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create the service, error: %s\n", err)
+		return 1
+	}
+	if getConfig().Diff {
+		return diffCommand(service)
+	}
+	if err := service.GenerateConfiguration(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to generate the configuration, error: %s\n", err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files")
+
+func newTestOfflineKubeAPI(t *testing.T) *offlineKubeAPI {
+	client, err := newOfflineKubeAPI([]string{"testdata/cluster"})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	return client
+}
+
+func TestOfflineKubeAPI(t *testing.T) {
+	client := newTestOfflineKubeAPI(t)
+
+	nodes, err := client.Nodes()
+	assert.Nil(t, err)
+	assert.Len(t, nodes, 2)
+
+	found, err := client.NamespaceExists("platform")
+	assert.Nil(t, err)
+	assert.True(t, found)
+	found, err = client.NamespaceExists("missing")
+	assert.Nil(t, err)
+	assert.False(t, found)
+
+	// step: only the running pods are returned
+	pods, err := client.Pods("default")
+	assert.Nil(t, err)
+	assert.Len(t, pods, 3)
+	pods, err = client.Pods("")
+	assert.Nil(t, err)
+	assert.Len(t, pods, 4)
+
+	defer func(selector string) { getConfig().PodLabelSelector = selector }(getConfig().PodLabelSelector)
+	getConfig().PodLabelSelector = "name=redis"
+	pods, err = client.Pods("")
+	assert.Nil(t, err)
+	if assert.Len(t, pods, 1) {
+		assert.Equal(t, "redis-j7k8l", pods[0].ID)
+		assert.Equal(t, "10.10.0.102", pods[0].Address)
+	}
+
+	defer func(selector string) { getConfig().NamespaceSelector = selector }(getConfig().NamespaceSelector)
+	getConfig().NamespaceSelector = "team=platform"
+	namespaces, err := client.Namespaces()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"platform"}, namespaces)
+
+	_, err = newOfflineKubeAPI([]string{"testdata/does_not_exist"})
+	assert.NotNil(t, err)
+}
+
+func TestValidateGenerateConfig(t *testing.T) {
+	assert.Nil(t, validateGenerateConfig(&Config{}))
+	assert.Nil(t, validateGenerateConfig(&Config{DryRun: true, Diff: true}))
+	assert.NotNil(t, validateGenerateConfig(&Config{ConfigMap: "monitoring/prometheus"}))
+	assert.NotNil(t, validateGenerateConfig(&Config{ReloadURL: "http://127.0.0.1:9090/-/reload"}))
+	assert.NotNil(t, validateGenerateConfig(&Config{ReloadPidFile: "/var/run/prometheus.pid"}))
+	assert.NotNil(t, validateGenerateConfig(&Config{ReloadProcess: "prometheus"}))
+
+	err := validateGenerateConfig(&Config{ConfigMap: "monitoring/prometheus", ReloadURL: "http://127.0.0.1:9090/-/reload"})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "the options: -configmap, -reload-url cannot be used when generating from the manifests", err.Error())
+	}
+}
+
+func TestGenerateGolden(t *testing.T) {
+	defer func(namespaces string, nodes bool, format string) {
+		getConfig().Namespaces, getConfig().WithNodes, getConfig().OutputFormat = namespaces, nodes, format
+	}(getConfig().Namespaces, getConfig().WithNodes, getConfig().OutputFormat)
+	getConfig().Namespaces = "default,platform"
+	getConfig().WithNodes = true
+	getConfig().OutputFormat = formatYAML
+
+	service, err := newPrometheusK8S(newTestOfflineKubeAPI(t))
+	assert.Nil(t, err)
+	sink := newFakeSink()
+	service.sink = sink
+	assert.Nil(t, service.GenerateConfiguration())
+
+	for _, filename := range []string{"nodes.yml", "pods.yml"} {
+		golden := filepath.Join("testdata/golden", filename)
+		if *updateGolden {
+			assert.Nil(t, ioutil.WriteFile(golden, sink.files[filename], 0644))
+			continue
+		}
+		expected, err := ioutil.ReadFile(golden)
+		assert.Nil(t, err)
+		assert.Equal(t, string(expected), string(sink.files[filename]), "file: %s", filename)
+	}
+}
@@ -17,8 +17,10 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
 	"fmt"
-	"strings"
+	"io/ioutil"
+	"sort"
 	"time"
 
 	"github.com/golang/glog"
@@ -32,166 +34,410 @@ func NewPrometheusK8S() (*PrometheusK8S, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	service, err := newPrometheusK8S(client)
+	if err != nil {
+		return nil, err
+	}
+
+	// step: only the leader writes the outputs when leader election is enabled
+	if getConfig().LeaderElect != "" {
+		if service.elector, err = newLeaderElector(client); err != nil {
+			return nil, err
+		}
+		service.sink = service.newSink(client)
+		service.elector.start()
+	}
+
+	return service, nil
+}
+
+// newPrometheusK8S creates the service with the client
+func newPrometheusK8S(client KubeAPI) (*PrometheusK8S, error) {
 	updatesCh := make(UpdateEvent, 10)
 
+	// step: load any of the user templates
+	templates, err := loadTemplates(getConfig().Templates)
+	if err != nil {
+		return nil, err
+	}
+
+	// step: create the reload notifier if required
+	var reloader *reloadNotifier
+	if reloadEnabled() {
+		reloader = newReloadNotifier()
+	}
+
 	return &PrometheusK8S{
 		client:    client,
 		updatesCh: updatesCh,
+		discovery: newDiscoveryStore(),
+		templates: templates,
+		reloader:  reloader,
+		sink:      newOutputSink(client),
+		reloadCh:  make(chan bool, 1),
+		newClient: NewKubeAPI,
 	}, nil
 }
 
+// newSink creates the output sink; with leader election a configmap, shared by the replicas, is only
+// written by the leader, while the files are local to each replica and always written
+func (r *PrometheusK8S) newSink(client KubeAPI) outputSink {
+	sink := newOutputSink(client)
+	if _, shared := sink.(*configMapSink); shared && r.elector != nil {
+		return &leaderSink{sink: sink, elector: r.elector}
+	}
+
+	return sink
+}
+
 // StartServiceProcessor starts the service processor
 func (r *PrometheusK8S) StartServiceProcessor() error {
 	// step: we start watching out for events from the api
-	if _, err := r.client.Watch(r.updatesCh); err != nil {
+	shutdownCh, err := r.getClient().Watch(r.updatesCh)
+	if err != nil {
 		glog.Errorf("failed to start watching out for events from kubernetes, error: %s", err)
 		return err
 	}
+	r.shutdownCh = shutdownCh
+	status.markWatching()
 
 	// step: lets create a ticker to enforce refreshing
-	ticker := time.NewTimer(time.Second * time.Duration(config.RefreshInterval))
+	interval := getConfig().RefreshInterval
+	ticker := time.NewTicker(time.Second * time.Duration(interval))
+	defer func() { ticker.Stop() }()
+	// step: create a heartbeat so we know the event loop is alive
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	// step: check the configuration file for changes
+	configCheck := time.NewTicker(configFileCheckInterval)
+	defer configCheck.Stop()
+	configContent := readConfigFileContent()
 
 	for {
+		status.markHeartbeat()
 		select {
+		case <-heartbeat.C:
+		case <-configCheck.C:
+			if content := readConfigFileContent(); content != nil && !bytes.Equal(content, configContent) {
+				glog.Infof("the configuration file: %s has changed", getConfig().ConfigFile)
+				configContent = content
+				r.requestConfigReload()
+			}
+		case <-r.reloadCh:
+			if err := r.reloadConfiguration(); err != nil {
+				glog.Errorf("failed to reload the configuration, keeping the current one, error: %s", err)
+				continue
+			}
+			if interval != getConfig().RefreshInterval {
+				interval = getConfig().RefreshInterval
+				ticker.Stop()
+				ticker = time.NewTicker(time.Second * time.Duration(interval))
+			}
+			r.GenerateConfiguration()
+		case <-r.elector.changes():
+			glog.V(4).Infof("the leadership has changed, regenerating the config")
+			r.GenerateConfiguration()
 		case <-ticker.C:
 			glog.V(5).Infof("we have received a refresh interval, regenerating the config")
 			r.GenerateConfiguration()
 		case event := <-r.updatesCh:
 			glog.V(4).Infof("we have received an update event from the watcher service, event: %s", event)
+			eventsMetric.WithLabelValues(event.getEventType(), event.getEventAction()).Inc()
 			// step: generate the content and write
 			r.GenerateConfiguration()
 		}
 	}
 }
 
+// readConfigFileContent returns the content of the configuration file, nil if not set or unreadable
+func readConfigFileContent() []byte {
+	if getConfig().ConfigFile == "" {
+		return nil
+	}
+	content, err := ioutil.ReadFile(getConfig().ConfigFile)
+	if err != nil {
+		glog.Warningf("unable to read the configuration file: %s, error: %s", getConfig().ConfigFile, err)
+		return nil
+	}
+
+	return content
+}
+
 // GenerateConfiguration render the configuration to file/s
-func (r *PrometheusK8S) GenerateConfiguration() error {
+func (r *PrometheusK8S) GenerateConfiguration() (err error) {
 	glog.Infof("generating the configuration of the prometheus nodes and services")
 
-	// step: are we generating the nodes?
-	if config.WithNodes {
-		content, err := r.generateNodesConfiguration()
+	// step: record the outcome of the generation
+	generationsMetric.Inc()
+	defer func(started time.Time) {
+		generationDurationMetric.Observe(time.Since(started).Seconds())
+		status.setGeneration(err)
 		if err != nil {
+			generationFailuresMetric.Inc()
+			return
+		}
+		lastGenerationMetric.Set(float64(time.Now().Unix()))
+	}(time.Now())
+
+	data := &templateData{
+		Targets: make(map[string][]*Targets, 0),
+	}
+
+	// step: are we generating the nodes?
+	if getConfig().WithNodes {
+		if data.Nodes, err = r.getClient().Nodes(); err != nil {
 			glog.Errorf("Unable to retrieve the list of nodes: error: %s", err)
 			return err
 		}
+		targets := r.generateNodesConfiguration(data.Nodes)
+		targetsMetric.WithLabelValues(getOutputFilename(getConfig().NodesConfigFilename)).Set(float64(countTargets(targets)))
+		data.Targets["nodes"] = targets
+		r.discovery.set("nodes", targets)
+
+		if writeErr := r.writeTargetsFile(targets, getConfig().NodesConfigFilename); writeErr != nil {
+			glog.Errorf("failed to write the node configuration, error: %s", writeErr)
+			err = writeErr
+		}
+	}
 
-		err = writeConfigFile(content, config.ConfigDirectory, config.NodesConfigFilename, config.DryRun)
-		if err != nil {
-			glog.Errorf("failed to write the node configuration, error: %s", err)
+	if getConfig().WithPods {
+		pods, podsErr := r.getPods()
+		if podsErr != nil {
+			glog.Errorf("gnable to retrieve the list of pods: error: %s", podsErr)
+			return podsErr
+		}
+		data.Pods = pods
+		services, invalid := r.groupPods(data.Pods)
+		for _, pod := range invalid {
+			decodeFailuresMetric.WithLabelValues(pod.Namespace).Inc()
+		}
+		data.services = services
+		targets := r.generatePodsConfiguration(data.Pods, data.services)
+		data.Targets["pods"] = targets
+		r.discovery.set("pods", targets)
+		r.discovery.setServices(targets)
+
+		if splitPods() {
+			if writeErr := r.writePodsFiles(targets); writeErr != nil {
+				glog.Errorf("failed to write the pods configuration, error: %s", writeErr)
+				err = writeErr
+			}
+		} else {
+			targetsMetric.WithLabelValues(getOutputFilename(getConfig().PodsConfigFilename)).Set(float64(countTargets(targets)))
+			if writeErr := r.writeTargetsFile(targets, getConfig().PodsConfigFilename); writeErr != nil {
+				glog.Errorf("failed to write the pods configuration, error: %s", writeErr)
+				err = writeErr
+			}
 		}
 	}
 
-	if config.WithPods {
-		content, err := r.generatePodsConfiguration()
-		if err != nil {
-			glog.Errorf("gnable to retrieve the list of pods: error: %s", err)
-			return err
+	// step: render the prometheus configuration if required; unlike the target files, changes
+	// to these files require prometheus to be reloaded
+	reload := false
+	if getConfig().ScrapeConfigBase != "" {
+		changed, writeErr := renderScrapeConfig(data, r.getSink())
+		if writeErr != nil {
+			glog.Errorf("failed to render the prometheus configuration, error: %s", writeErr)
+			err = writeErr
 		}
+		reload = reload || changed
+	}
 
-		err = writeConfigFile(content, config.ConfigDirectory, config.PodsConfigFilename, config.DryRun)
-		if err != nil {
-			glog.Errorf("failed to write the pods configuration, error: %s", err)
+	// step: write the alerting rules of the pods
+	if getConfig().AlertsFilePattern != "" && getConfig().WithPods {
+		changed, writeErr := r.writeAlertFiles(data.services)
+		if writeErr != nil {
+			glog.Errorf("failed to write the alerting rules, error: %s", writeErr)
+			err = writeErr
+		}
+		reload = reload || changed
+	}
+
+	// step: render any of the user templates
+	for _, tmpl := range r.getTemplates() {
+		changed, writeErr := tmpl.render(data, r.getSink())
+		if writeErr != nil {
+			glog.Errorf("failed to render the template: %s, error: %s", tmpl.source, writeErr)
+			err = writeErr
+		}
+		reload = reload || changed
+	}
+
+	// step: notify prometheus if the configuration has changed; the change is kept pending
+	// until a generation succeeds and prometheus has been reloaded
+	if reloader := r.getReloader(); reloader != nil {
+		if reload {
+			reloader.markPending()
+		}
+		if err == nil && reloader.isPending() {
+			reloader.trigger()
 		}
 	}
 
-	return nil
+	return err
 }
 
-// generateNodesConfiguration generates the node config
-func (r *PrometheusK8S) generateNodesConfiguration() ([]byte, error) {
-	glog.V(4).Infof("generating the nodes configuration")
-	// step: get the current list of nodes from the kubernetes
-	nodes, err := r.client.Nodes()
+// writeTargetsFile encodes the target groups into the output format and writes the file
+func (r *PrometheusK8S) writeTargetsFile(targets []*Targets, filename string) error {
+	content, err := encodeAs(targets, getConfig().OutputFormat)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("Failed to marshall the target into format, error: %s", err)
 	}
+	_, err = r.getSink().write(getOutputFilename(filename), content)
+
+	return err
+}
+
+// generateNodesConfiguration generates the node target groups
+func (r *PrometheusK8S) generateNodesConfiguration(nodes []*Node) []*Targets {
+	glog.V(4).Infof("generating the nodes configuration")
 
 	// step: create the targets group
 	var targets []*Targets
 
 	targets = append(targets, newTarget())
 	for _, node := range nodes {
-		targets[0].Targets = append(targets[0].Targets, fmt.Sprintf("%s:%d", node.ID, config.NodePort))
+		targets[0].Targets = append(targets[0].Targets, fmt.Sprintf("%s:%d", node.ID, getConfig().NodePort))
 	}
+	sort.Strings(targets[0].Targets)
 	targets[0].Labels["role"] = "kubernetes_node"
 
-	// step: marshall the config
-	output, err := encode(targets)
-	if err != nil {
-		glog.Errorf("Failed to marshall the data, error: %s", err)
-	}
-
-	return output, nil
+	return targets
 }
 
-// renderPods: write the pod config to disk
-func (r *PrometheusK8S) generatePodsConfiguration() ([]byte, error) {
-	glog.V(4).Infof("generating the pod services configuration, namespaces: %s", config.Namespaces)
+// getPods retrieves the running pods from the namespaces we are generating for
+func (r *PrometheusK8S) getPods() ([]*Pod, error) {
+	var list []*Pod
 
-	var content []byte
-	var targets []*Targets
+	// step: get the namespaces we are generating for
+	namespaces, err := r.getClient().Namespaces()
+	if err != nil {
+		glog.Errorf("unable to retrieve the list of namespaces, error: %s", err)
+		return nil, err
+	}
 
 	// step: get the current listing of pods
-	namespaces := strings.Split(config.Namespaces, ",")
-
 	for _, namespace := range namespaces {
 		// step: check the namespace exists and if not, just skipp
-		found, err := r.client.NamespaceExists(namespace)
+		found, err := r.getClient().NamespaceExists(namespace)
 		if err != nil {
 			glog.Errorf("unable to determine if the namespace: %s exists, error: %s", namespace, err)
-			return content, err
+			return nil, err
 		} else if !found {
 			glog.Warningf("the namespace: %s does not exist, skipping retrieveing config", namespace)
 			continue
 		}
 
 		// step: grab the pods within the specified namespace
-		pods, err := r.client.Pods(namespace)
+		pods, err := r.getClient().Pods(namespace)
 		if err != nil {
 			glog.Errorf("unable to retrieve the list of pods with namespace: %s, error: %s", namespace, err)
-			return content, err
+			return nil, err
 		}
 
 		glog.V(5).Infof("retrieved %d pods from namespace: %s, pods: #%v", len(pods), namespace, pods)
 
-		// step: we iterate around and find all pods of the same 'Name' - effectively we are
-		// grouping by the spec.labels['name'] for target groups, we also filter out any pods
-		// which do not have a metrics annotation
-		serviceGroups := make(map[string][]*Metrics, 0)
-		for _, pod := range pods {
-			// step: check if this pod name has already been found
-			if _, found := serviceGroups[pod.Name]; found {
-				continue
-			}
-			// step: check of the pod has annotations
-			if _, found = pod.Annotations[config.MetricAnnotation]; !found {
-				continue
-			}
+		list = append(list, pods...)
+	}
 
-			// check: decode the metrics annotations
-			metrics, err := decodeMetrics(pod.Annotations[config.MetricAnnotation])
-			if err != nil {
-				glog.Errorf("skipping pod: '%s', name: '%s' as the metrics config is invalid, error: %s", pod.ID, pod.Name, err)
-				continue
-			}
+	// step: sort the pods, the labels of a group are copied from each of its pods in turn
+	sort.Sort(podsByName(list))
+
+	return list, nil
+}
+
+// podsByName is a list of pods sortable by namespace and name
+type podsByName []*Pod
+
+func (r podsByName) Len() int      { return len(r) }
+func (r podsByName) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r podsByName) Less(i, j int) bool {
+	if r[i].Namespace != r[j].Namespace {
+		return r[i].Namespace < r[j].Namespace
+	}
+	return r[i].ID < r[j].ID
+}
+
+// serviceKey is the namespace and name the pods are grouped by
+type serviceKey struct {
+	// the namespace of the pods
+	namespace string
+	// the name of the pods
+	name string
+}
+
+// serviceKeys is a sortable list of service keys
+type serviceKeys []serviceKey
+
+func (r serviceKeys) Len() int      { return len(r) }
+func (r serviceKeys) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r serviceKeys) Less(i, j int) bool {
+	if r[i].namespace != r[j].namespace {
+		return r[i].namespace < r[j].namespace
+	}
+	return r[i].name < r[j].name
+}
+
+// sortedServiceKeys returns the keys of the services in a stable order
+func sortedServiceKeys(services map[serviceKey][]*Metrics) []serviceKey {
+	keys := make([]serviceKey, 0, len(services))
+	for key := range services {
+		keys = append(keys, key)
+	}
+	sort.Sort(serviceKeys(keys))
 
-			serviceGroups[pod.Name] = metrics
+	return keys
+}
+
+// groupPods groups the pods by namespace and 'Name' - effectively we are grouping by the
+// spec.labels['name'], the metrics are decoded from the first pod of each group which has
+// a metrics annotation, groups without one are filtered out; the pods whose annotation could
+// not be decoded are returned alongside
+func (r *PrometheusK8S) groupPods(pods []*Pod) (map[serviceKey][]*Metrics, []*Pod) {
+	var invalid []*Pod
+	serviceGroups := make(map[serviceKey][]*Metrics, 0)
+	for _, pod := range pods {
+		key := serviceKey{namespace: pod.Namespace, name: pod.Name}
+		// step: check if this pod name has already been found
+		if _, found := serviceGroups[key]; found {
+			continue
+		}
+		// step: check of the pod has annotations
+		if _, found := pod.Annotations[getConfig().MetricAnnotation]; !found {
+			continue
 		}
 
-		// check: if we have nothing to render, lets continue
-		if len(serviceGroups) <= 0 {
+		// check: decode the metrics annotations
+		metrics, err := decodeMetrics(pod.Annotations[getConfig().MetricAnnotation])
+		if err != nil {
+			glog.Errorf("skipping pod: '%s', name: '%s' as the metrics config is invalid, error: %s", pod.ID, pod.Name, err)
+			invalid = append(invalid, pod)
 			continue
 		}
 
-		// step: now we iterate the pods again, group by the service_names and produce
-		// the target groups per service name
-		for serviceName, metrics := range serviceGroups {
+		serviceGroups[key] = metrics
+	}
+
+	return serviceGroups, invalid
+}
+
+// generatePodsConfiguration generates the pod target groups
+func (r *PrometheusK8S) generatePodsConfiguration(pods []*Pod, serviceGroups map[serviceKey][]*Metrics) []*Targets {
+	glog.V(4).Infof("generating the pod services configuration, namespaces: %s", getConfig().Namespaces)
+
+	var targets []*Targets
+
+	// step: now we iterate the pods again, group by the service_names and produce
+	// the target groups per service name, the groups are sorted so the output is stable
+	for _, key := range sortedServiceKeys(serviceGroups) {
+		for _, entries := range splitMetrics(serviceGroups[key]) {
 			target := newTarget()
-			target.Labels["pod"] = serviceName
+			target.Labels["pod"] = key.name
+			target.pods = make(map[string]string, 0)
 
 			for _, pod := range pods {
-				if pod.Name == serviceName {
+				if pod.Namespace == key.namespace && pod.Name == key.name {
 					// step: copy in the rest of the pod labels
 					target.Labels["namespace"] = pod.Namespace
 					for k, v := range pod.Labels {
@@ -201,22 +447,31 @@ func (r *PrometheusK8S) generatePodsConfiguration() ([]byte, error) {
 						target.Labels[k] = v
 					}
 					// step: we produce a endpoint for each metrics listed
-					for _, metric := range metrics {
-						target.Targets = append(target.Targets, fmt.Sprintf("%s:%d", pod.Address, metric.Port))
+					for _, metric := range entries {
+						address := fmt.Sprintf("%s:%d", pod.Address, metric.Port)
+						target.Targets = append(target.Targets, address)
+						target.pods[address] = pod.ID
 					}
 				}
 			}
 
+			sort.Strings(target.Targets)
+
+			// step: name the group after the entry when grouping per endpoint
+			if perEndpointGroups() {
+				target.endpoint = metricName(entries[0])
+				if getConfig().EndpointLabel != "" {
+					target.Labels[getConfig().EndpointLabel] = target.endpoint
+				}
+				if entries[0].Endpoint != "" {
+					target.Labels["__metrics_path__"] = entries[0].Endpoint
+				}
+			}
+
 			// step: append the group to the groups
 			targets = append(targets, target)
 		}
 	}
 
-	// step: marshall the config into format
-	content, err := encode(targets)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to marshall the target into format, error: %s", err)
-	}
-
-	return content, nil
+	return targets
 }
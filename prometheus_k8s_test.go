@@ -27,21 +27,132 @@ func newTestPrometheusK8S(t *testing.T) *PrometheusK8S {
 	return &PrometheusK8S{
 		client:    fakeAPI,
 		updatesCh: make(UpdateEvent, 10),
+		discovery: newDiscoveryStore(),
+		sink:      newFakeSink(),
 	}
 }
 
 func TestGeneratePodsConfiguration(t *testing.T) {
 	ks8 := newTestPrometheusK8S(t)
-	content, err := ks8.generatePodsConfiguration()
+	pods, err := ks8.getPods()
+	assert.Nil(t, err)
+	services, _ := ks8.groupPods(pods)
+	targets := ks8.generatePodsConfiguration(pods, services)
+	assert.NotEmpty(t, targets)
+	content, err := encode(targets)
 	assert.Nil(t, err)
 	assert.NotEmpty(t, content)
 	t.Logf("pod config:\n%s", content)
 }
 
+func TestGroupPodsInvalid(t *testing.T) {
+	ks8 := newTestPrometheusK8S(t)
+	pods := []*Pod{
+		{ID: "web-1", Name: "web", Namespace: "default", Annotations: map[string]string{getConfig().MetricAnnotation: "- port: 80"}},
+		{ID: "api-1", Name: "api", Namespace: "default", Annotations: map[string]string{getConfig().MetricAnnotation: "- port: [80"}},
+		{ID: "db-1", Name: "db", Namespace: "default"},
+	}
+	services, invalid := ks8.groupPods(pods)
+	assert.Len(t, services, 1)
+	assert.Contains(t, services, serviceKey{namespace: "default", name: "web"})
+	if assert.Len(t, invalid, 1) {
+		assert.Equal(t, "api-1", invalid[0].ID)
+	}
+}
+
 func TestGenerateNodesConfiguration(t *testing.T) {
 	ks8 := newTestPrometheusK8S(t)
-	content, err := ks8.generateNodesConfiguration()
+	nodes, err := ks8.client.Nodes()
+	assert.Nil(t, err)
+	targets := ks8.generateNodesConfiguration(nodes)
+	assert.NotEmpty(t, targets)
+	content, err := encode(targets)
 	assert.Nil(t, err)
 	assert.NotEmpty(t, content)
 	t.Logf("node config:\n%s", content)
 }
+
+func TestGeneratePodsConfigurationPerEndpoint(t *testing.T) {
+	defer func(label string) { getConfig().EndpointLabel = label }(getConfig().EndpointLabel)
+	getConfig().EndpointLabel = "job"
+
+	ks8 := newTestPrometheusK8S(t)
+	pods := []*Pod{
+		{
+			ID:        "web-1",
+			Name:      "web",
+			Namespace: "default",
+			Annotations: map[string]string{
+				getConfig().MetricAnnotation: "- name: webapp\n  port: 8080\n  endpoint: /stats\n- port: 9103\n",
+			},
+			Address: "10.0.0.1",
+		},
+	}
+	services, _ := ks8.groupPods(pods)
+	targets := ks8.generatePodsConfiguration(pods, services)
+	assert.Len(t, targets, 2)
+
+	found := make(map[string]*Targets, 0)
+	for _, target := range targets {
+		found[target.Labels["job"]] = target
+	}
+	if assert.Contains(t, found, "webapp") {
+		assert.Equal(t, []string{"10.0.0.1:8080"}, found["webapp"].Targets)
+		assert.Equal(t, "/stats", found["webapp"].Labels["__metrics_path__"])
+	}
+	if assert.Contains(t, found, "9103") {
+		assert.Equal(t, []string{"10.0.0.1:9103"}, found["9103"].Targets)
+		assert.NotContains(t, found["9103"].Labels, "__metrics_path__")
+	}
+
+	getConfig().EndpointLabel = ""
+	services, _ = ks8.groupPods(pods)
+	targets = ks8.generatePodsConfiguration(pods, services)
+	assert.Len(t, targets, 1)
+	assert.Len(t, targets[0].Targets, 2)
+	assert.NotContains(t, targets[0].Labels, "job")
+}
+
+func TestGenerateConfigurationStable(t *testing.T) {
+	defer func(namespaces string, nodes bool, format string) {
+		getConfig().Namespaces, getConfig().WithNodes, getConfig().OutputFormat = namespaces, nodes, format
+	}(getConfig().Namespaces, getConfig().WithNodes, getConfig().OutputFormat)
+	getConfig().WithNodes = true
+
+	for _, format := range []string{formatYAML, formatJSON} {
+		getConfig().OutputFormat = format
+		var previous map[string][]byte
+		for i := 0; i < 20; i++ {
+			ks8 := newTestPrometheusK8S(t)
+			assert.Nil(t, ks8.GenerateConfiguration())
+			files := ks8.sink.(*fakeSink).files
+			if previous != nil {
+				assert.Equal(t, previous, files, "format: %s, run: %d", format, i)
+			}
+			previous = files
+		}
+	}
+}
+
+func TestGeneratePodsConfigurationSorted(t *testing.T) {
+	ks8 := newTestPrometheusK8S(t)
+	pods := []*Pod{
+		{ID: "web-2", Name: "web", Namespace: "default", Address: "10.0.0.9", Annotations: map[string]string{getConfig().MetricAnnotation: "- port: 80\n"}},
+		{ID: "api-1", Name: "api", Namespace: "default", Address: "10.0.0.5", Annotations: map[string]string{getConfig().MetricAnnotation: "- port: 80\n"}},
+		{ID: "web-1", Name: "web", Namespace: "default", Address: "10.0.0.1", Annotations: map[string]string{getConfig().MetricAnnotation: "- port: 80\n"}},
+		{ID: "db-1", Name: "db", Namespace: "backend", Address: "10.0.0.7", Annotations: map[string]string{getConfig().MetricAnnotation: "- port: 80\n"}},
+	}
+	services, _ := ks8.groupPods(pods)
+	targets := ks8.generatePodsConfiguration(pods, services)
+	if assert.Len(t, targets, 3) {
+		assert.Equal(t, "db", targets[0].Labels["pod"])
+		assert.Equal(t, "api", targets[1].Labels["pod"])
+		assert.Equal(t, "web", targets[2].Labels["pod"])
+		assert.Equal(t, []string{"10.0.0.1:80", "10.0.0.9:80"}, targets[2].Targets)
+	}
+
+	// step: the labels are emitted in a sorted order
+	content, err := encodeAs(targets[:1], formatJSON)
+	assert.Nil(t, err)
+	assert.Contains(t, string(content), "\"labels\": {\n      \"namespace\": \"backend\",\n      \"pod\": \"db\"\n    }")
+}
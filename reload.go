@@ -0,0 +1,209 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// the number of attempts we make to reload prometheus
+	reloadAttempts = 5
+	// the initial time we wait between reload attempts
+	reloadBackoff = time.Second
+)
+
+// reloadNotifier reloads prometheus, either by a post to the reload url or by sending a SIGHUP
+// to the process found via a pid file or by name within a shared process namespace
+type reloadNotifier struct {
+	// the channel used to request a reload
+	requestCh chan bool
+	// the initial backoff between attempts
+	backoff time.Duration
+	// set while a change has not yet been reloaded into prometheus
+	pending int32
+}
+
+// reloadEnabled checks if prometheus should be reloaded; never in a dry run or diff, where the
+// files are not written and the changes reported are not real
+func reloadEnabled() bool {
+	if getConfig().DryRun || getConfig().Diff {
+		return false
+	}
+
+	return getConfig().ReloadURL != "" || getConfig().ReloadPidFile != "" || getConfig().ReloadProcess != ""
+}
+
+// newReloadNotifier creates and starts a new reload notifier
+func newReloadNotifier() *reloadNotifier {
+	r := &reloadNotifier{
+		requestCh: make(chan bool, 1),
+		backoff:   reloadBackoff,
+	}
+	go r.run()
+
+	return r
+}
+
+// markPending records a change which prometheus has to be reloaded for; it stays pending until
+// a reload succeeds, so the change is not lost when a generation fails or the reload gives up
+func (r *reloadNotifier) markPending() {
+	atomic.StoreInt32(&r.pending, 1)
+}
+
+// isPending checks if a change is waiting to be reloaded into prometheus
+func (r *reloadNotifier) isPending() bool {
+	return atomic.LoadInt32(&r.pending) == 1
+}
+
+// trigger requests a reload, requests made while a reload is pending are coalesced
+func (r *reloadNotifier) trigger() {
+	select {
+	case r.requestCh <- true:
+	default:
+		glog.V(4).Infof("a reload of prometheus is already pending")
+	}
+}
+
+// stop stops the notifier, any pending request is still performed
+func (r *reloadNotifier) stop() {
+	close(r.requestCh)
+}
+
+// run waits for reload requests and performs them
+func (r *reloadNotifier) run() {
+	for range r.requestCh {
+		// step: clear the pending change, any change made while we reload is marked again
+		atomic.StoreInt32(&r.pending, 0)
+		if err := r.reloadWithRetry(); err != nil {
+			glog.Errorf("failed to reload prometheus after %d attempts, error: %s", reloadAttempts, err)
+			// step: re-arm the change so the next generation requests the reload again
+			r.markPending()
+		}
+	}
+}
+
+// reloadWithRetry attempts to reload prometheus, backing off between the attempts
+func (r *reloadNotifier) reloadWithRetry() error {
+	var err error
+	backoff := r.backoff
+	for attempt := 1; attempt <= reloadAttempts; attempt++ {
+		reloadsMetric.Inc()
+		if err = r.reload(); err == nil {
+			glog.Infof("successfully reloaded prometheus")
+			return nil
+		}
+		reloadFailuresMetric.Inc()
+		glog.Warningf("failed to reload prometheus, attempt: %d, error: %s", attempt, err)
+
+		if attempt < reloadAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	return err
+}
+
+// reload performs a single reload of prometheus
+func (r *reloadNotifier) reload() error {
+	if getConfig().ReloadURL != "" {
+		return reloadByURL(getConfig().ReloadURL)
+	}
+
+	pid, err := findReloadPid()
+	if err != nil {
+		return err
+	}
+	glog.V(4).Infof("sending a SIGHUP to prometheus, pid: %d", pid)
+
+	return syscall.Kill(pid, syscall.SIGHUP)
+}
+
+// reloadByURL posts to the reload endpoint of prometheus
+func reloadByURL(url string) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response from: %s, code: %d", url, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// findReloadPid finds the pid of prometheus, from the pid file or by the process name
+func findReloadPid() (int, error) {
+	if getConfig().ReloadPidFile != "" {
+		return readPidFile(getConfig().ReloadPidFile)
+	}
+
+	return findProcess("/proc", getConfig().ReloadProcess)
+}
+
+// readPidFile reads the pid from the file
+func readPidFile(filename string) (int, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return 0, fmt.Errorf("unable to read the pid file: %s, error: %s", filename, err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid pid in the file: %s", filename)
+	}
+
+	return pid, nil
+}
+
+// findProcess searches the proc filesystem for a process whose command matches the name
+func findProcess(procfs, name string) (int, error) {
+	entries, err := ioutil.ReadDir(procfs)
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		cmdline, err := ioutil.ReadFile(filepath.Join(procfs, entry.Name(), "cmdline"))
+		if err != nil || len(cmdline) == 0 {
+			continue
+		}
+		command := strings.SplitN(string(cmdline), "\x00", 2)[0]
+		if filepath.Base(command) == name {
+			return pid, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unable to find the process: %s", name)
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadEnabled(t *testing.T) {
+	defer func(url string, dryRun, diff bool) {
+		getConfig().ReloadURL, getConfig().DryRun, getConfig().Diff = url, dryRun, diff
+	}(getConfig().ReloadURL, getConfig().DryRun, getConfig().Diff)
+	getConfig().ReloadURL = "http://127.0.0.1:9090/-/reload"
+	getConfig().DryRun, getConfig().Diff = false, false
+
+	assert.True(t, reloadEnabled())
+	getConfig().DryRun = true
+	assert.False(t, reloadEnabled())
+	service, err := newPrometheusK8S(newFakeKubeAPI(t))
+	assert.Nil(t, err)
+	assert.Nil(t, service.reloader)
+	getConfig().DryRun, getConfig().Diff = false, true
+	assert.False(t, reloadEnabled())
+}
+
+func TestReloadByURL(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		attempts++
+		assert.Equal(t, "POST", req.Method)
+		if attempts < 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	defer func(original string) { getConfig().ReloadURL = original }(getConfig().ReloadURL)
+	getConfig().ReloadURL = server.URL + "/-/reload"
+
+	notifier := &reloadNotifier{backoff: time.Millisecond}
+	assert.Nil(t, notifier.reloadWithRetry())
+	assert.Equal(t, 3, attempts)
+
+	getConfig().ReloadURL = "http://127.0.0.1:0/-/reload"
+	assert.NotNil(t, notifier.reloadWithRetry())
+}
+
+func TestReloadPending(t *testing.T) {
+	defer func(original string) { getConfig().ReloadURL = original }(getConfig().ReloadURL)
+	getConfig().ReloadURL = "http://127.0.0.1:0/-/reload"
+
+	notifier := &reloadNotifier{requestCh: make(chan bool, 1), backoff: time.Millisecond}
+	assert.False(t, notifier.isPending())
+	notifier.markPending()
+	assert.True(t, notifier.isPending())
+
+	// check: a failed reload keeps the change pending
+	notifier.trigger()
+	notifier.stop()
+	notifier.run()
+	assert.True(t, notifier.isPending())
+
+	// check: a successful reload clears it
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer server.Close()
+	getConfig().ReloadURL = server.URL + "/-/reload"
+
+	notifier = &reloadNotifier{requestCh: make(chan bool, 1), backoff: time.Millisecond}
+	notifier.markPending()
+	notifier.trigger()
+	notifier.stop()
+	notifier.run()
+	assert.False(t, notifier.isPending())
+}
+
+func TestReloadByPidFile(t *testing.T) {
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, syscall.SIGHUP)
+	defer signal.Stop(signalCh)
+
+	file, err := ioutil.TempFile("", "pid")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+	fmt.Fprintf(file, "%d\n", os.Getpid())
+	file.Close()
+
+	defer func(original string) { getConfig().ReloadPidFile = original }(getConfig().ReloadPidFile)
+	getConfig().ReloadPidFile = file.Name()
+
+	notifier := &reloadNotifier{backoff: time.Millisecond}
+	assert.Nil(t, notifier.reload())
+	select {
+	case <-signalCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("we did not receive the SIGHUP")
+	}
+}
+
+func TestReadPidFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "pid")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+
+	file.WriteString("not a pid")
+	file.Close()
+	_, err = readPidFile(file.Name())
+	assert.NotNil(t, err)
+	_, err = readPidFile("/does/not/exist")
+	assert.NotNil(t, err)
+}
+
+func TestFindProcess(t *testing.T) {
+	procfs, err := ioutil.TempDir("", "proc")
+	assert.Nil(t, err)
+	defer os.RemoveAll(procfs)
+
+	for pid, cmdline := range map[string]string{
+		"10":   "/bin/prometheus\x00-config.file=/etc/prometheus/prometheus.yml\x00",
+		"20":   "/bin/sh\x00",
+		"self": "/bin/prometheus\x00",
+	} {
+		os.MkdirAll(filepath.Join(procfs, pid), 0755)
+		ioutil.WriteFile(filepath.Join(procfs, pid, "cmdline"), []byte(cmdline), 0644)
+	}
+
+	pid, err := findProcess(procfs, "prometheus")
+	assert.Nil(t, err)
+	assert.Equal(t, 10, pid)
+	_, err = findProcess(procfs, "grafana")
+	assert.NotNil(t, err)
+}
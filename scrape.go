@@ -0,0 +1,255 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	"github.com/golang/glog"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// a scrape job per output file
+	scrapeJobsPerFile = "file"
+	// a scrape job per metrics entry of each service
+	scrapeJobsPerService = "service"
+)
+
+// scrapeConfig is a prometheus scrape job
+type scrapeConfig struct {
+	// the name of the job
+	JobName string `yaml:"job_name"`
+	// the scrape interval
+	ScrapeInterval string `yaml:"scrape_interval,omitempty"`
+	// the scrape timeout
+	ScrapeTimeout string `yaml:"scrape_timeout,omitempty"`
+	// the path of the metrics
+	MetricsPath string `yaml:"metrics_path,omitempty"`
+	// the scheme, http or https
+	Scheme string `yaml:"scheme,omitempty"`
+	// the file discovery configs
+	FileSDConfigs []*fileSDConfig `yaml:"file_sd_configs"`
+	// the relabel configs
+	RelabelConfigs []*relabelConfig `yaml:"relabel_configs,omitempty"`
+}
+
+// fileSDConfig is a prometheus file discovery config
+type fileSDConfig struct {
+	// the files containing the targets
+	Files []string `yaml:"files"`
+}
+
+// relabelConfig is a prometheus relabel config
+type relabelConfig struct {
+	// the labels to match on
+	SourceLabels []string `yaml:"source_labels"`
+	// the regex to match
+	Regex string `yaml:"regex"`
+	// the action to take
+	Action string `yaml:"action"`
+}
+
+var (
+	// the format of a prometheus duration
+	durationRegex = regexp.MustCompile(`^[0-9]+(ms|s|m|h|d|w|y)$`)
+)
+
+// renderScrapeConfig renders the prometheus configuration from the base configuration, adding
+// the scrape jobs for the generated target files; we return true if the configuration changed
+func renderScrapeConfig(data *templateData, sink outputSink) (bool, error) {
+	content, err := ioutil.ReadFile(getConfig().ScrapeConfigBase)
+	if err != nil {
+		return false, fmt.Errorf("unable to read the base configuration: %s, error: %s", getConfig().ScrapeConfigBase, err)
+	}
+
+	// step: generate the jobs
+	var jobs []*scrapeConfig
+	switch getConfig().ScrapeJobs {
+	case scrapeJobsPerService:
+		jobs = generateServiceScrapeJobs(data.services)
+	default:
+		jobs = generateFileScrapeJobs()
+	}
+
+	content, err = mergeScrapeJobs(content, jobs)
+	if err != nil {
+		return false, fmt.Errorf("unable to merge the base configuration: %s, error: %s", getConfig().ScrapeConfigBase, err)
+	}
+
+	// step: add the generated alerting rules to the rule files
+	if getConfig().AlertsFilePattern != "" {
+		if content, err = mergeRuleFiles(content, getAlertsFilenameGlob()); err != nil {
+			return false, fmt.Errorf("unable to merge the rule files of the base configuration: %s, error: %s", getConfig().ScrapeConfigBase, err)
+		}
+	}
+
+	return sink.write(getConfig().ScrapeConfigFilename, content)
+}
+
+// mergeScrapeJobs appends the jobs to the scrape configs of the base configuration
+func mergeScrapeJobs(base []byte, jobs []*scrapeConfig) ([]byte, error) {
+	var cfg yaml.MapSlice
+	if err := yaml.Unmarshal(base, &cfg); err != nil {
+		return nil, err
+	}
+
+	var scrapeConfigs []interface{}
+	index := -1
+	for i, item := range cfg {
+		if item.Key == "scrape_configs" {
+			index = i
+			if item.Value != nil {
+				list, ok := item.Value.([]interface{})
+				if !ok {
+					return nil, fmt.Errorf("the scrape_configs should be a list")
+				}
+				scrapeConfigs = list
+			}
+		}
+	}
+	for _, job := range jobs {
+		scrapeConfigs = append(scrapeConfigs, job)
+	}
+	if index < 0 {
+		cfg = append(cfg, yaml.MapItem{Key: "scrape_configs", Value: scrapeConfigs})
+	} else {
+		cfg[index].Value = scrapeConfigs
+	}
+
+	return yaml.Marshal(cfg)
+}
+
+// mergeRuleFiles adds the rule file to the rule files of the base configuration, if not already present
+func mergeRuleFiles(base []byte, filename string) ([]byte, error) {
+	var cfg yaml.MapSlice
+	if err := yaml.Unmarshal(base, &cfg); err != nil {
+		return nil, err
+	}
+
+	for i, item := range cfg {
+		if item.Key != "rule_files" {
+			continue
+		}
+		var files []interface{}
+		if item.Value != nil {
+			list, ok := item.Value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("the rule_files should be a list")
+			}
+			files = list
+		}
+		for _, existing := range files {
+			if existing == filename {
+				return yaml.Marshal(cfg)
+			}
+		}
+		cfg[i].Value = append(files, filename)
+
+		return yaml.Marshal(cfg)
+	}
+	cfg = append(cfg, yaml.MapItem{Key: "rule_files", Value: []string{filename}})
+
+	return yaml.Marshal(cfg)
+}
+
+// generateFileScrapeJobs generates a job for each of the target files, the files are relative to the
+// prometheus configuration, which is written into the same directory
+func generateFileScrapeJobs() []*scrapeConfig {
+	var jobs []*scrapeConfig
+	if getConfig().WithNodes {
+		jobs = append(jobs, &scrapeConfig{
+			JobName:       "nodes",
+			FileSDConfigs: []*fileSDConfig{{Files: []string{getOutputFilename(getConfig().NodesConfigFilename)}}},
+		})
+	}
+	if getConfig().WithPods {
+		jobs = append(jobs, &scrapeConfig{
+			JobName:       "pods",
+			FileSDConfigs: []*fileSDConfig{{Files: []string{getPodsFilenameGlob()}}},
+		})
+	}
+
+	return jobs
+}
+
+// serviceJobName returns the name of the scrape job of the metrics entry of the service
+func serviceJobName(key serviceKey, metric *Metrics) string {
+	return fmt.Sprintf("%s/%s/%s", key.namespace, key.name, metricName(metric))
+}
+
+// generateServiceScrapeJobs generates a job for each of the metrics entries of the services, each job
+// uses the pods targets file and keeps only the targets of the service and port; prometheus refuses
+// a configuration with duplicate job names, so an entry whose job already exists is skipped
+func generateServiceScrapeJobs(services map[serviceKey][]*Metrics) []*scrapeConfig {
+	var jobs []*scrapeConfig
+	found := make(map[string]bool, 0)
+
+	// step: sort the services so the jobs are rendered in a stable order
+	for _, key := range sortedServiceKeys(services) {
+		for _, metric := range services[key] {
+			name := metricName(metric)
+			if found[serviceJobName(key, metric)] {
+				glog.Errorf("skipping the metrics entry: %s, port: %d of the service: %s/%s, the job: %s already exists",
+					name, metric.Port, key.namespace, key.name, serviceJobName(key, metric))
+				continue
+			}
+			found[serviceJobName(key, metric)] = true
+			job := &scrapeConfig{
+				JobName:       serviceJobName(key, metric),
+				MetricsPath:   metric.Endpoint,
+				FileSDConfigs: []*fileSDConfig{{Files: []string{getPodsFilename(key.namespace, key.name, name)}}},
+				RelabelConfigs: []*relabelConfig{
+					{
+						SourceLabels: []string{"namespace", "pod", "__address__"},
+						Regex:        fmt.Sprintf("%s;%s;.*:%d", regexp.QuoteMeta(key.namespace), regexp.QuoteMeta(key.name), metric.Port),
+						Action:       "keep",
+					},
+				},
+			}
+			switch metric.Scheme {
+			case "", "http", "https":
+				job.Scheme = metric.Scheme
+			default:
+				glog.Warningf("ignoring the invalid scheme: %s for the job: %s", metric.Scheme, job.JobName)
+			}
+			if job.ScrapeInterval = metric.Interval; job.ScrapeInterval != "" && !durationRegex.MatchString(job.ScrapeInterval) {
+				glog.Warningf("ignoring the invalid scrape interval: %s for the job: %s", metric.Interval, job.JobName)
+				job.ScrapeInterval = ""
+			}
+			if job.ScrapeTimeout = metric.Timeout; job.ScrapeTimeout != "" && !durationRegex.MatchString(job.ScrapeTimeout) {
+				glog.Warningf("ignoring the invalid scrape timeout: %s for the job: %s", metric.Timeout, job.JobName)
+				job.ScrapeTimeout = ""
+			}
+			if job.ScrapeInterval != "" && job.ScrapeTimeout != "" {
+				interval, _ := durationSeconds(job.ScrapeInterval)
+				timeout, _ := durationSeconds(job.ScrapeTimeout)
+				if timeout > interval {
+					glog.Warningf("ignoring the scrape timeout: %s for the job: %s, it is greater than the interval: %s",
+						job.ScrapeTimeout, job.JobName, job.ScrapeInterval)
+					job.ScrapeTimeout = ""
+				}
+			}
+
+			jobs = append(jobs, job)
+		}
+	}
+
+	return jobs
+}
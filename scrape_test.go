@@ -0,0 +1,98 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestMergeScrapeJobs(t *testing.T) {
+	base, err := ioutil.ReadFile("testdata/prometheus-base.yml")
+	assert.Nil(t, err)
+
+	jobs := []*scrapeConfig{{JobName: "pods", FileSDConfigs: []*fileSDConfig{{Files: []string{"pods.yml"}}}}}
+	content, err := mergeScrapeJobs(base, jobs)
+	assert.Nil(t, err)
+
+	var merged struct {
+		Global        map[string]string `yaml:"global"`
+		ScrapeConfigs []*scrapeConfig   `yaml:"scrape_configs"`
+	}
+	assert.Nil(t, yaml.Unmarshal(content, &merged))
+	assert.Equal(t, "30s", merged.Global["scrape_interval"])
+	assert.Len(t, merged.ScrapeConfigs, 2)
+	assert.Equal(t, "prometheus", merged.ScrapeConfigs[0].JobName)
+	assert.Equal(t, "pods", merged.ScrapeConfigs[1].JobName)
+	assert.Equal(t, []string{"pods.yml"}, merged.ScrapeConfigs[1].FileSDConfigs[0].Files)
+
+	// step: a base without any scrape configs
+	content, err = mergeScrapeJobs([]byte("global:\n  scrape_interval: 30s\n"), jobs)
+	assert.Nil(t, err)
+	assert.Contains(t, string(content), "job_name: pods")
+
+	_, err = mergeScrapeJobs([]byte("scrape_configs: true\n"), jobs)
+	assert.NotNil(t, err)
+}
+
+func TestGenerateServiceScrapeJobs(t *testing.T) {
+	services := map[serviceKey][]*Metrics{
+		{namespace: "platform", name: "prometheus"}: {
+			{Port: 9090},
+		},
+		{namespace: "default", name: "webapp"}: {
+			{Name: "webapp", Port: 8080, Endpoint: "/stats", Scheme: "https", Interval: "15s", Timeout: "bad"},
+			{Name: "nginx", Port: 8081, Scheme: "ftp"},
+		},
+	}
+	jobs := generateServiceScrapeJobs(services)
+	assert.Len(t, jobs, 3)
+
+	assert.Equal(t, "default/webapp/webapp", jobs[0].JobName)
+	assert.Equal(t, "/stats", jobs[0].MetricsPath)
+	assert.Equal(t, "https", jobs[0].Scheme)
+	assert.Equal(t, "15s", jobs[0].ScrapeInterval)
+	assert.Empty(t, jobs[0].ScrapeTimeout)
+	assert.Equal(t, "default;webapp;.*:8080", jobs[0].RelabelConfigs[0].Regex)
+
+	assert.Equal(t, "default/webapp/nginx", jobs[1].JobName)
+	assert.Empty(t, jobs[1].Scheme)
+	assert.Equal(t, "platform/prometheus/9090", jobs[2].JobName)
+}
+
+func TestGenerateServiceScrapeJobsInvalid(t *testing.T) {
+	services := map[serviceKey][]*Metrics{
+		{namespace: "default", name: "webapp"}: {
+			{Name: "webapp", Port: 8080, Interval: "10s", Timeout: "30s"},
+			{Name: "webapp", Port: 8081},
+			{Name: "9100", Port: 8082, Interval: "30s", Timeout: "10s"},
+			{Port: 9100},
+		},
+	}
+	jobs := generateServiceScrapeJobs(services)
+	if assert.Len(t, jobs, 2) {
+		assert.Equal(t, "default/webapp/webapp", jobs[0].JobName)
+		assert.Equal(t, "10s", jobs[0].ScrapeInterval)
+		assert.Empty(t, jobs[0].ScrapeTimeout)
+		assert.Equal(t, "default/webapp/9100", jobs[1].JobName)
+		assert.Equal(t, "default;webapp;.*:8082", jobs[1].RelabelConfigs[0].Regex)
+		assert.Equal(t, "10s", jobs[1].ScrapeTimeout)
+	}
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// newHTTPHandler creates the http handler for the service endpoints
+func (r *PrometheusK8S) newHTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", healthHandler)
+	mux.HandleFunc("/readyz", readinessHandler)
+	mux.HandleFunc("/sd/", r.discoveryHandler)
+	// step: the debug endpoints expose the pods of the cluster and are opt in
+	if getConfig().EnableDebug {
+		mux.HandleFunc("/debug/explain", r.explainHandler)
+	}
+
+	return mux
+}
+
+// startHTTPServer starts the http listener for the service endpoints
+func (r *PrometheusK8S) startHTTPServer() error {
+	listener, err := net.Listen("tcp", getConfig().ListenAddress)
+	if err != nil {
+		return fmt.Errorf("unable to listen on: %s, error: %s", getConfig().ListenAddress, err)
+	}
+	glog.Infof("starting the http service on: %s", getConfig().ListenAddress)
+
+	go func() {
+		if err := http.Serve(listener, r.newHTTPHandler()); err != nil {
+			glog.Errorf("the http service has failed, error: %s", err)
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+)
+
+const (
+	// shard the targets by the namespace
+	shardKeyNamespace = "namespace"
+	// shard the targets by the service
+	shardKeyService = "service"
+	// shard the targets by the pod
+	shardKeyPod = "pod"
+	// the filename of the shards, the placeholder is replaced with the index of the shard
+	shardFilename = "pods-shard-%s.yml"
+)
+
+// getShardFilename returns the name of the file the targets of the shard are written to
+func getShardFilename(shard int) string {
+	return getOutputFilename(fmt.Sprintf(shardFilename, strconv.Itoa(shard)))
+}
+
+// getShardKey returns the key the target is sharded by, a pod is identified by its name so the
+// assignment survives the pod being rescheduled onto another address
+func getShardKey(namespace, service, pod string) string {
+	switch getConfig().ShardKey {
+	case shardKeyNamespace:
+		return namespace
+	case shardKeyPod:
+		return namespace + "/" + pod
+	default:
+		return namespace + "/" + service
+	}
+}
+
+// getShard returns the shard the key is assigned to
+func getShard(key string, shards int) int {
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+
+	return jumpHash(hash.Sum64(), shards)
+}
+
+// jumpHash is the jump consistent hash of Lamping and Veach; when the number of buckets changes
+// only the keys which must move are reassigned, i.e. going from n to n+1 buckets moves 1/(n+1) of them
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+
+	return int(b)
+}
+
+// shardTargets assigns the pods target groups to the shards; when sharding by pod a group is split
+// so each target is assigned individually. Every shard is returned, even if empty, so each prometheus
+// always has a file to read
+func shardTargets(targets []*Targets, shards int) [][]*Targets {
+	list := make([][]*Targets, shards)
+	for i := range list {
+		list[i] = make([]*Targets, 0)
+	}
+
+	for _, target := range targets {
+		namespace, service := target.Labels["namespace"], target.Labels["pod"]
+		if getConfig().ShardKey != shardKeyPod {
+			shard := getShard(getShardKey(namespace, service, ""), shards)
+			list[shard] = append(list[shard], target)
+			continue
+		}
+
+		// step: split the group by the pod of each target
+		groups := make(map[int]*Targets, 0)
+		for _, address := range target.Targets {
+			shard := getShard(getShardKey(namespace, service, target.pods[address]), shards)
+			if _, found := groups[shard]; !found {
+				group := *target
+				group.Targets = nil
+				groups[shard] = &group
+				list[shard] = append(list[shard], &group)
+			}
+			groups[shard].Targets = append(groups[shard].Targets, address)
+		}
+	}
+
+	// step: record the number of targets of each shard
+	shardTargetsMetric.Reset()
+	for i, shard := range list {
+		shardTargetsMetric.WithLabelValues(strconv.Itoa(i)).Set(float64(countTargets(shard)))
+	}
+
+	return list
+}
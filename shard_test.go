@@ -0,0 +1,163 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newShardTargets(services ...string) []*Targets {
+	var list []*Targets
+	for i, service := range services {
+		target := newTarget()
+		target.Labels["namespace"] = "default"
+		target.Labels["pod"] = service
+		target.pods = make(map[string]string, 0)
+		for j := 1; j <= 3; j++ {
+			address := fmt.Sprintf("10.0.%d.%d:80", i, j)
+			target.Targets = append(target.Targets, address)
+			target.pods[address] = fmt.Sprintf("%s-%d", service, j)
+		}
+		list = append(list, target)
+	}
+
+	return list
+}
+
+func TestJumpHash(t *testing.T) {
+	// step: growing the shards only moves keys onto the new shard, and only a fraction of them
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("default/service-%d", i)
+		before, after := getShard(key, 4), getShard(key, 5)
+		assert.True(t, before >= 0 && before < 4)
+		if before != after {
+			assert.Equal(t, 4, after)
+			moved++
+		}
+	}
+	assert.True(t, moved > 100 && moved < 300, "moved %d of the keys", moved)
+	assert.Equal(t, 0, getShard("default/web", 1))
+}
+
+func TestShardTargets(t *testing.T) {
+	defer func(key string) { getConfig().ShardKey = key }(getConfig().ShardKey)
+	getConfig().ShardKey = shardKeyService
+
+	targets := newShardTargets("web", "api", "worker", "cache", "queue")
+	shards := shardTargets(targets, 3)
+	assert.Len(t, shards, 3)
+	total := 0
+	for _, shard := range shards {
+		assert.NotNil(t, shard)
+		for _, target := range shard {
+			assert.Len(t, target.Targets, 3)
+		}
+		total += len(shard)
+	}
+	assert.Equal(t, len(targets), total)
+	assert.Equal(t, shards, shardTargets(targets, 3))
+}
+
+func TestShardTargetsByPod(t *testing.T) {
+	defer func(key string) { getConfig().ShardKey = key }(getConfig().ShardKey)
+	getConfig().ShardKey = shardKeyPod
+
+	targets := newShardTargets("web", "api")
+	shards := shardTargets(targets, 4)
+	total := 0
+	for i, shard := range shards {
+		for _, target := range shard {
+			for _, address := range target.Targets {
+				assert.Equal(t, i, getShard(getShardKey("default", target.Labels["pod"], target.pods[address]), 4))
+			}
+			total += len(target.Targets)
+		}
+	}
+	assert.Equal(t, 6, total)
+
+	// check: a pod keeps its shard when rescheduled onto another address
+	before := shardTargets(newShardTargets("web"), 4)
+	rescheduled := newShardTargets("web")
+	for _, target := range rescheduled {
+		pods := make(map[string]string, 0)
+		for j, address := range target.Targets {
+			target.Targets[j] = strings.Replace(address, "10.0.", "10.9.", 1)
+			pods[target.Targets[j]] = target.pods[address]
+		}
+		target.pods = pods
+	}
+	after := shardTargets(rescheduled, 4)
+	for i := range before {
+		var expected, actual []string
+		for _, target := range before[i] {
+			for _, address := range target.Targets {
+				expected = append(expected, target.pods[address])
+			}
+		}
+		for _, target := range after[i] {
+			for _, address := range target.Targets {
+				actual = append(actual, target.pods[address])
+			}
+		}
+		assert.Equal(t, expected, actual, "shard: %d", i)
+	}
+}
+
+func TestGeneratePodsConfigurationPods(t *testing.T) {
+	ks8 := newTestPrometheusK8S(t)
+	pods := []*Pod{
+		{ID: "web-1", Name: "web", Namespace: "default", Address: "10.0.0.1", Phase: "Running"},
+		{ID: "web-2", Name: "web", Namespace: "default", Address: "10.0.0.2", Phase: "Running"},
+	}
+	services := map[serviceKey][]*Metrics{{namespace: "default", name: "web"}: {{Port: 80}}}
+	targets := ks8.generatePodsConfiguration(pods, services)
+	if assert.Len(t, targets, 1) {
+		assert.Equal(t, map[string]string{"10.0.0.1:80": "web-1", "10.0.0.2:80": "web-2"}, targets[0].pods)
+	}
+}
+
+func TestWriteShardFiles(t *testing.T) {
+	defer func(shards int, key, format string) {
+		getConfig().Shards, getConfig().ShardKey, getConfig().OutputFormat = shards, key, format
+	}(getConfig().Shards, getConfig().ShardKey, getConfig().OutputFormat)
+	getConfig().OutputFormat = formatYAML
+	getConfig().ShardKey = shardKeyService
+	getConfig().Shards = 6
+
+	ks8 := newTestPrometheusK8S(t)
+	sink := ks8.sink.(*fakeSink)
+	sink.files["nodes.yml"] = []byte("[]")
+	sink.files["pods-shard-9.yml"] = []byte("[]")
+	assert.Nil(t, ks8.writePodsFiles(newShardTargets("web", "api")))
+	assert.Contains(t, sink.files, "pods-shard-5.yml")
+
+	getConfig().Shards = 3
+	assert.Nil(t, ks8.writePodsFiles(newShardTargets("web", "api")))
+	assert.Contains(t, sink.files, "pods-shard-0.yml")
+	assert.Contains(t, sink.files, "pods-shard-1.yml")
+	assert.Contains(t, sink.files, "pods-shard-2.yml")
+	assert.Contains(t, sink.files, "nodes.yml")
+	assert.NotContains(t, sink.files, "pods-shard-5.yml")
+	assert.NotContains(t, sink.files, "pods-shard-9.yml")
+	assert.Equal(t, "pods-shard-*.yml", getPodsFilenameGlob())
+	assert.True(t, isTargetsFile("pods-shard-12.yml"))
+}
@@ -0,0 +1,234 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/api/errors"
+)
+
+const (
+	// the number of attempts we make to update a configmap on a conflict
+	configMapAttempts = 3
+)
+
+// configMapKeyRegex is the valid format of a key within a configmap
+var configMapKeyRegex = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+
+// outputSink is where the generated files are written to
+type outputSink interface {
+	// write the content under the filename, we return true if the content changed
+	write(filename string, content []byte) (bool, error)
+	// list the files in the sink
+	list() ([]string, error)
+	// remove the file from the sink
+	remove(filename string) error
+}
+
+// dryRunSink writes the content to the screen
+type dryRunSink struct{}
+
+// fileSink writes the content into files within a directory
+type fileSink struct {
+	// the directory to write the files into
+	directory string
+}
+
+// configMapSink writes the content into the keys of a configmap
+type configMapSink struct {
+	// the client for k8s
+	client KubeAPI
+	// the namespace of the configmap
+	namespace string
+	// the name of the configmap
+	name string
+}
+
+// newOutputSink creates the sink the configuration is written to
+func newOutputSink(client KubeAPI) outputSink {
+	switch {
+	case getConfig().DryRun:
+		return &dryRunSink{}
+	case getConfig().Diff:
+		return &diffSink{directory: getConfig().ConfigDirectory, output: os.Stdout}
+	case getConfig().ConfigMap != "":
+		namespace, name, _ := parseConfigMapOption(getConfig().ConfigMap)
+		return &configMapSink{client: client, namespace: namespace, name: name}
+	default:
+		return &fileSink{directory: getConfig().ConfigDirectory}
+	}
+}
+
+// write prints the content to stdout
+func (r *dryRunSink) write(filename string, content []byte) (bool, error) {
+	_, err := os.Stdout.Write(content)
+
+	return true, err
+}
+
+// list returns nothing, as nothing is written
+func (r *dryRunSink) list() ([]string, error) {
+	return nil, nil
+}
+
+// remove prints the file which would be removed
+func (r *dryRunSink) remove(filename string) error {
+	_, err := fmt.Fprintf(os.Stdout, "# removed: %s\n", filename)
+
+	return err
+}
+
+// write writes the content into the file atomically, the file is not written if the content
+// has not changed
+func (r *fileSink) write(filename string, content []byte) (bool, error) {
+	// check: if the content has not changed we can skip the write
+	path := filepath.Join(r.directory, filename)
+	if existing, err := ioutil.ReadFile(path); err == nil && bytes.Equal(existing, content) {
+		glog.V(5).Infof("the content of the file: %s has not changed, skipping the write", path)
+		return false, nil
+	}
+
+	fileWritesMetric.WithLabelValues(filename).Inc()
+	if err := writeFileAtomic(path, content); err != nil {
+		fileWriteErrorsMetric.WithLabelValues(filename).Inc()
+		return false, err
+	}
+
+	return true, nil
+}
+
+// list returns the files within the directory
+func (r *fileSink) list() ([]string, error) {
+	files, err := ioutil.ReadDir(r.directory)
+	if err != nil {
+		return nil, err
+	}
+	var list []string
+	for _, file := range files {
+		if file.Mode().IsRegular() {
+			list = append(list, file.Name())
+		}
+	}
+
+	return list, nil
+}
+
+// remove deletes the file from the directory
+func (r *fileSink) remove(filename string) error {
+	if err := os.Remove(filepath.Join(r.directory, filename)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// write writes the content into a key of the configmap, creating the configmap if required; the
+// configmap is not updated if the content has not changed
+func (r *configMapSink) write(filename string, content []byte) (bool, error) {
+	if !configMapKeyRegex.MatchString(filename) {
+		return false, fmt.Errorf("the filename: %s is not a valid configmap key", filename)
+	}
+
+	var err error
+	for attempt := 1; attempt <= configMapAttempts; attempt++ {
+		var changed bool
+		if changed, err = r.update(filename, string(content)); err == nil || !errors.IsConflict(err) {
+			return changed, err
+		}
+		glog.V(4).Infof("conflict updating the configmap: %s/%s, retrying", r.namespace, r.name)
+	}
+
+	return false, err
+}
+
+// list returns the keys within the configmap
+func (r *configMapSink) list() ([]string, error) {
+	cm, err := r.client.ConfigMap(r.namespace, r.name)
+	if err != nil || cm == nil {
+		return nil, err
+	}
+	var list []string
+	for key := range cm.Data {
+		list = append(list, key)
+	}
+
+	return list, nil
+}
+
+// remove deletes the key from the configmap
+func (r *configMapSink) remove(filename string) error {
+	var err error
+	for attempt := 1; attempt <= configMapAttempts; attempt++ {
+		var cm *ConfigMap
+		if cm, err = r.client.ConfigMap(r.namespace, r.name); err != nil || cm == nil {
+			return err
+		}
+		if _, found := cm.Data[filename]; !found {
+			return nil
+		}
+		delete(cm.Data, filename)
+		if err = r.client.SaveConfigMap(cm); err == nil || !errors.IsConflict(err) {
+			return err
+		}
+	}
+
+	return err
+}
+
+// update performs a single create or update of the key in the configmap
+func (r *configMapSink) update(key, value string) (bool, error) {
+	cm, err := r.client.ConfigMap(r.namespace, r.name)
+	if err != nil {
+		return false, err
+	}
+	if cm == nil {
+		cm = &ConfigMap{Namespace: r.namespace, Name: r.name}
+	}
+	if current, found := cm.Data[key]; found && current == value {
+		glog.V(5).Infof("the key: %s in the configmap: %s/%s has not changed, skipping the update", key, r.namespace, r.name)
+		return false, nil
+	}
+	if cm.Data == nil {
+		cm.Data = make(map[string]string, 0)
+	}
+	cm.Data[key] = value
+
+	fileWritesMetric.WithLabelValues(key).Inc()
+	if err := r.client.SaveConfigMap(cm); err != nil {
+		fileWriteErrorsMetric.WithLabelValues(key).Inc()
+		return false, err
+	}
+
+	return true, nil
+}
+
+// parseConfigMapOption splits the configmap option into the namespace and name
+func parseConfigMapOption(option string) (string, string, error) {
+	items := regexp.MustCompile(`^([-a-z0-9]+)/([-.a-z0-9]+)$`).FindStringSubmatch(option)
+	if items == nil {
+		return "", "", fmt.Errorf("invalid configmap: %s, should be in the format of namespace/name", option)
+	}
+
+	return items[1], items[2], nil
+}
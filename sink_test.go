@@ -0,0 +1,168 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/api/errors"
+)
+
+// fakeConfigMapAPI stores the configmaps in memory
+type fakeConfigMapAPI struct {
+	fakeKubeAPI
+	// the configmaps keyed by namespace/name
+	items map[string]*ConfigMap
+	// the number of times a configmap was saved
+	saves int
+	// the number of conflicts to return on save
+	conflicts int
+}
+
+func (r *fakeConfigMapAPI) ConfigMap(namespace, name string) (*ConfigMap, error) {
+	cm, found := r.items[namespace+"/"+name]
+	if !found {
+		return nil, nil
+	}
+	copied := *cm
+	copied.Data = make(map[string]string, 0)
+	for k, v := range cm.Data {
+		copied.Data[k] = v
+	}
+
+	return &copied, nil
+}
+
+func (r *fakeConfigMapAPI) SaveConfigMap(cm *ConfigMap) error {
+	if r.conflicts > 0 {
+		r.conflicts--
+		return errors.NewConflict("configmaps", cm.Name, nil)
+	}
+	r.saves++
+	cm.ResourceVersion = "1"
+	r.items[cm.Namespace+"/"+cm.Name] = cm
+
+	return nil
+}
+
+// fakeSink keeps the written files in memory
+type fakeSink struct {
+	// the content of the files
+	files map[string][]byte
+}
+
+func newFakeSink() *fakeSink {
+	return &fakeSink{files: make(map[string][]byte, 0)}
+}
+
+func (r *fakeSink) write(filename string, content []byte) (bool, error) {
+	changed := string(r.files[filename]) != string(content)
+	r.files[filename] = content
+
+	return changed, nil
+}
+
+func (r *fakeSink) list() ([]string, error) {
+	var list []string
+	for filename := range r.files {
+		list = append(list, filename)
+	}
+
+	return list, nil
+}
+
+func (r *fakeSink) remove(filename string) error {
+	delete(r.files, filename)
+
+	return nil
+}
+
+func TestFileSink(t *testing.T) {
+	directory, err := ioutil.TempDir("", "sink")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+
+	sink := &fileSink{directory: directory}
+	changed, err := sink.write("pods.yml", []byte("content"))
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	changed, err = sink.write("pods.yml", []byte("content"))
+	assert.Nil(t, err)
+	assert.False(t, changed)
+
+	content, err := ioutil.ReadFile(filepath.Join(directory, "pods.yml"))
+	assert.Nil(t, err)
+	assert.Equal(t, "content", string(content))
+
+	list, err := sink.list()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"pods.yml"}, list)
+	assert.Nil(t, sink.remove("pods.yml"))
+	assert.Nil(t, sink.remove("pods.yml"))
+	assert.False(t, fileExists(filepath.Join(directory, "pods.yml")))
+}
+
+func TestConfigMapSink(t *testing.T) {
+	client := &fakeConfigMapAPI{items: make(map[string]*ConfigMap, 0)}
+	sink := &configMapSink{client: client, namespace: "monitoring", name: "targets"}
+
+	changed, err := sink.write("pods.yml", []byte("pods"))
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	changed, err = sink.write("nodes.yml", []byte("nodes"))
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	changed, err = sink.write("pods.yml", []byte("pods"))
+	assert.Nil(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, 2, client.saves)
+	assert.Equal(t, map[string]string{"pods.yml": "pods", "nodes.yml": "nodes"}, client.items["monitoring/targets"].Data)
+
+	// step: conflicts are retried
+	client.conflicts = 1
+	changed, err = sink.write("pods.yml", []byte("updated"))
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	client.conflicts = configMapAttempts
+	_, err = sink.write("pods.yml", []byte("again"))
+	assert.NotNil(t, err)
+
+	_, err = sink.write("dir/pods.yml", []byte("pods"))
+	assert.NotNil(t, err)
+
+	list, err := sink.list()
+	assert.Nil(t, err)
+	assert.Len(t, list, 2)
+	assert.Nil(t, sink.remove("nodes.yml"))
+	assert.Equal(t, map[string]string{"pods.yml": "updated"}, client.items["monitoring/targets"].Data)
+}
+
+func TestParseConfigMapOption(t *testing.T) {
+	namespace, name, err := parseConfigMapOption("monitoring/prometheus-targets")
+	assert.Nil(t, err)
+	assert.Equal(t, "monitoring", namespace)
+	assert.Equal(t, "prometheus-targets", name)
+
+	for _, option := range []string{"", "name", "/name", "namespace/", "a/b/c", "Monitoring/name"} {
+		_, _, err := parseConfigMapOption(option)
+		assert.NotNil(t, err, "option: %s", option)
+	}
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// the placeholder for the namespace in the pods file pattern
+	patternNamespace = "{namespace}"
+	// the placeholder for the service in the pods file pattern
+	patternService = "{service}"
+	// the placeholder for the metrics entry in the pods file pattern
+	patternEndpoint = "{endpoint}"
+)
+
+// validatePodsFilePattern checks the pattern used to split the pods targets into files
+func validatePodsFilePattern(pattern string) error {
+	if !strings.Contains(pattern, patternNamespace) && !strings.Contains(pattern, patternEndpoint) {
+		return fmt.Errorf("the pods file pattern: %s must contain %s or %s", pattern, patternNamespace, patternEndpoint)
+	}
+	if strings.HasPrefix(pattern, "{") {
+		return fmt.Errorf("the pods file pattern: %s must start with a literal prefix, i.e. pods-", pattern)
+	}
+	if strings.Contains(pattern, "/") {
+		return fmt.Errorf("the pods file pattern: %s must not contain a directory", pattern)
+	}
+	remaining := strings.NewReplacer(patternNamespace, "", patternService, "", patternEndpoint, "").Replace(pattern)
+	if strings.ContainsAny(remaining, "{}*?[]") {
+		return fmt.Errorf("the pods file pattern: %s contains an unknown placeholder or glob character", pattern)
+	}
+
+	return nil
+}
+
+// getFilenamePrefix returns the literal prefix of the filename pattern, up to the first placeholder
+func getFilenamePrefix(pattern string) string {
+	if index := strings.IndexAny(pattern, "{%"); index >= 0 {
+		return pattern[:index]
+	}
+
+	return pattern
+}
+
+// getPodsFilename returns the name of the file the targets of the service or endpoint are written to,
+// the values are sanitized as the endpoint is taken from the annotations of the pods
+func getPodsFilename(namespace, service, endpoint string) string {
+	return formatPodsFilename(sanitizeFilename(namespace), sanitizeFilename(service), sanitizeFilename(endpoint))
+}
+
+// formatPodsFilename replaces the placeholders of the pods file pattern with the values as given
+func formatPodsFilename(namespace, service, endpoint string) string {
+	if getConfig().PodsFilePattern == "" {
+		return getOutputFilename(getConfig().PodsConfigFilename)
+	}
+	replacer := strings.NewReplacer(patternNamespace, namespace, patternService, service, patternEndpoint, endpoint)
+
+	return getOutputFilename(replacer.Replace(getConfig().PodsFilePattern))
+}
+
+// splitPods checks if the pods targets are split into multiple files, by a pattern or into shards
+func splitPods() bool {
+	return getConfig().PodsFilePattern != "" || getConfig().Shards > 0
+}
+
+// getPodsFilenameGlob returns a glob matching all of the pods files, used for the file_sd configuration
+func getPodsFilenameGlob() string {
+	if getConfig().Shards > 0 {
+		return getOutputFilename(fmt.Sprintf(shardFilename, "*"))
+	}
+
+	return formatPodsFilename("*", "*", "*")
+}
+
+// getPodsFilenameRegex returns a regex matching all of the files produced by the pods file pattern
+// or the shards
+func getPodsFilenameRegex() *regexp.Regexp {
+	if getConfig().Shards > 0 {
+		expression := regexp.QuoteMeta(getOutputFilename(fmt.Sprintf(shardFilename, "0")))
+		return regexp.MustCompile("^" + strings.Replace(expression, "0", "[0-9]+", 1) + "$")
+	}
+
+	return getFilenameRegex(formatPodsFilename(patternNamespace, patternService, patternEndpoint))
+}
+
+// getFilenameRegex returns a regex matching the filename with any of the placeholders replaced
+func getFilenameRegex(filename string) *regexp.Regexp {
+	expression := strings.NewReplacer(
+		regexp.QuoteMeta(patternNamespace), `[-a-z0-9.]+`,
+		regexp.QuoteMeta(patternService), `[-a-zA-Z0-9_.]*`,
+		regexp.QuoteMeta(patternEndpoint), `[-a-zA-Z0-9_.]+`).Replace(regexp.QuoteMeta(filename))
+
+	return regexp.MustCompile("^" + expression + "$")
+}
+
+// splitTargets splits the pods target groups into the files they are written to, by the namespace
+// and service labels and the endpoint of each group, or into the shards
+func splitTargets(targets []*Targets) map[string][]*Targets {
+	files := make(map[string][]*Targets, 0)
+	if getConfig().Shards > 0 {
+		for i, shard := range shardTargets(targets, getConfig().Shards) {
+			files[getShardFilename(i)] = shard
+		}
+		return files
+	}
+	for _, target := range targets {
+		if strings.Contains(getConfig().PodsFilePattern, patternEndpoint) && !isValidFilename(sanitizeFilename(target.endpoint)) {
+			glog.Errorf("skipping the targets of the service: %s/%s, the endpoint: %q cannot be used in a filename",
+				target.Labels["namespace"], target.Labels["pod"], target.endpoint)
+			continue
+		}
+		filename := getPodsFilename(target.Labels["namespace"], target.Labels["pod"], target.endpoint)
+		files[filename] = append(files[filename], target)
+	}
+
+	return files
+}
+
+// writePodsFiles writes the pods target groups into a file per namespace or service, removing any
+// files matching the pattern or the shards which are no longer required
+func (r *PrometheusK8S) writePodsFiles(targets []*Targets) error {
+	var err error
+	files := splitTargets(targets)
+
+	// step: write the files in a stable order
+	filenames := make([]string, 0, len(files))
+	for filename := range files {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	for _, filename := range filenames {
+		targetsMetric.WithLabelValues(filename).Set(float64(countTargets(files[filename])))
+		if writeErr := r.writeTargetsFile(files[filename], filename); writeErr != nil {
+			glog.Errorf("failed to write the pods file: %s, error: %s", filename, writeErr)
+			err = writeErr
+		}
+	}
+
+	// step: remove the files for namespaces, services or shards which no longer exist, including those
+	// written by a previous run; any other file in the output is left alone
+	existing, listErr := r.getSink().list()
+	if listErr != nil {
+		return fmt.Errorf("unable to list the existing pods files, error: %s", listErr)
+	}
+	reserved := r.reservedFiles()
+	regex := getPodsFilenameRegex()
+	for _, filename := range existing {
+		if _, found := files[filename]; found || reserved[filename] || !regex.MatchString(filename) {
+			continue
+		}
+		glog.Infof("removing the pods file: %s, the namespace, service or shard no longer exists", filename)
+		targetsMetric.DeleteLabelValues(filename)
+		if removeErr := r.getSink().remove(filename); removeErr != nil {
+			glog.Errorf("failed to remove the pods file: %s, error: %s", filename, removeErr)
+			err = removeErr
+		}
+	}
+
+	return err
+}
+
+// reservedFiles returns the other files we generate, which must never be written over or removed as
+// stale pods or rule files
+func (r *PrometheusK8S) reservedFiles() map[string]bool {
+	reserved := map[string]bool{
+		getOutputFilename(getConfig().NodesConfigFilename): true,
+		getOutputFilename(getConfig().PodsConfigFilename):  true,
+		getConfig().ScrapeConfigFilename:                   true,
+	}
+	for _, tmpl := range r.getTemplates() {
+		reserved[tmpl.filename] = true
+	}
+
+	return reserved
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePodsFilePattern(t *testing.T) {
+	assert.Nil(t, validatePodsFilePattern("pods-{namespace}.yml"))
+	assert.Nil(t, validatePodsFilePattern("pods-{namespace}-{service}.yml"))
+	assert.Nil(t, validatePodsFilePattern("pods-{endpoint}.yml"))
+	assert.NotNil(t, validatePodsFilePattern("{endpoint}.yml"))
+	assert.NotNil(t, validatePodsFilePattern("{namespace}-pods.yml"))
+	assert.NotNil(t, validatePodsFilePattern("pods-{service}.yml"))
+	assert.NotNil(t, validatePodsFilePattern("pods/{namespace}.yml"))
+	assert.NotNil(t, validatePodsFilePattern("pods-{namespace}-{pod}.yml"))
+	assert.NotNil(t, validatePodsFilePattern("pods-{namespace}-*.yml"))
+}
+
+func TestGetPodsFilename(t *testing.T) {
+	defer func(pattern, format string) {
+		getConfig().PodsFilePattern, getConfig().OutputFormat = pattern, format
+	}(getConfig().PodsFilePattern, getConfig().OutputFormat)
+	getConfig().OutputFormat = formatYAML
+
+	getConfig().PodsFilePattern = ""
+	assert.Equal(t, getOutputFilename(getConfig().PodsConfigFilename), getPodsFilename("default", "web", "webapp"))
+	getConfig().PodsFilePattern = "pods-{namespace}.yml"
+	assert.Equal(t, "pods-default.yml", getPodsFilename("default", "web", "webapp"))
+	assert.Equal(t, "pods-*.yml", getPodsFilenameGlob())
+	getConfig().PodsFilePattern = "pods-{namespace}-{service}.yml"
+	assert.Equal(t, "pods-default-web.yml", getPodsFilename("default", "web", "webapp"))
+	getConfig().PodsFilePattern = "pods-{endpoint}.yml"
+	assert.Equal(t, "pods-webapp.yml", getPodsFilename("default", "web", "webapp"))
+	assert.Equal(t, "pods-.._.._etc_passwd.yml", getPodsFilename("default", "web", "../../etc/passwd"))
+	getConfig().PodsFilePattern = "pods-{namespace}-{service}.yml"
+	getConfig().OutputFormat = formatJSON
+	assert.Equal(t, "pods-default-web.json", getPodsFilename("default", "web", "webapp"))
+
+	regex := getPodsFilenameRegex()
+	assert.True(t, regex.MatchString("pods-default-web.json"))
+	assert.False(t, regex.MatchString("pods-default-web.yml"))
+	assert.False(t, regex.MatchString("nodes.json"))
+}
+
+func TestWritePodsFiles(t *testing.T) {
+	defer func(pattern, format string) {
+		getConfig().PodsFilePattern, getConfig().OutputFormat = pattern, format
+	}(getConfig().PodsFilePattern, getConfig().OutputFormat)
+	getConfig().OutputFormat = formatYAML
+	getConfig().PodsFilePattern = "pods-{namespace}.yml"
+
+	ks8 := newTestPrometheusK8S(t)
+	sink := ks8.sink.(*fakeSink)
+	sink.files["pods-other.yml"] = []byte("[]")
+	sink.files["prometheus.yml"] = []byte("global: {}")
+
+	newTargets := func(namespaces ...string) []*Targets {
+		var list []*Targets
+		for _, namespace := range namespaces {
+			target := newTarget()
+			target.Targets = []string{"10.0.0.1:80"}
+			target.Labels["namespace"] = namespace
+			target.Labels["pod"] = "web"
+			list = append(list, target)
+		}
+		return list
+	}
+
+	assert.Nil(t, ks8.writePodsFiles(newTargets("default", "team-a")))
+	assert.Contains(t, sink.files, "pods-default.yml")
+	assert.Contains(t, sink.files, "pods-team-a.yml")
+	assert.Contains(t, sink.files, "prometheus.yml")
+
+	// check: the stale files matching the pattern are removed, including those from a previous run
+	sink.files["rules.yml"] = []byte("groups: []")
+	assert.Nil(t, ks8.writePodsFiles(newTargets("default")))
+	assert.Contains(t, sink.files, "pods-default.yml")
+	assert.NotContains(t, sink.files, "pods-team-a.yml")
+	assert.NotContains(t, sink.files, "pods-other.yml")
+	assert.Contains(t, sink.files, "prometheus.yml")
+	assert.Contains(t, sink.files, "rules.yml")
+}
+
+func TestWritePodsFilesDiff(t *testing.T) {
+	directory, err := ioutil.TempDir("", "diff")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+	defer func(pattern, format string) {
+		getConfig().PodsFilePattern, getConfig().OutputFormat = pattern, format
+	}(getConfig().PodsFilePattern, getConfig().OutputFormat)
+	getConfig().OutputFormat = formatYAML
+	getConfig().PodsFilePattern = "pods-{namespace}.yml"
+
+	stale := "- targets: [\"10.0.0.9:80\"]\n  labels: {namespace: old}\n"
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(directory, "pods-old.yml"), []byte(stale), 0644))
+
+	// check: a file left by a previous run is reported as removed
+	output := new(bytes.Buffer)
+	ks8 := newTestPrometheusK8S(t)
+	ks8.sink = &diffSink{directory: directory, output: output}
+	target := newTarget()
+	target.Targets = []string{"10.0.0.1:80"}
+	target.Labels["namespace"] = "default"
+	assert.Nil(t, ks8.writePodsFiles([]*Targets{target}))
+	assert.Contains(t, output.String(), "--- pods-old.yml would be removed")
+	assert.Contains(t, output.String(), `- 10.0.0.9:80 {namespace="old"}`)
+	assert.FileExists(t, filepath.Join(directory, "pods-old.yml"))
+}
+
+func TestSplitTargetsInvalidEndpoint(t *testing.T) {
+	defer func(pattern, format string) {
+		getConfig().PodsFilePattern, getConfig().OutputFormat = pattern, format
+	}(getConfig().PodsFilePattern, getConfig().OutputFormat)
+	getConfig().OutputFormat = formatYAML
+	getConfig().PodsFilePattern = "pods-{endpoint}.yml"
+
+	var targets []*Targets
+	for _, endpoint := range []string{"web", "..", ".", "a/b"} {
+		target := newTarget()
+		target.Targets = []string{"10.0.0.1:80"}
+		target.Labels["namespace"] = "default"
+		target.Labels["pod"] = "web"
+		target.endpoint = endpoint
+		targets = append(targets, target)
+	}
+
+	files := splitTargets(targets)
+	assert.Equal(t, 2, len(files))
+	assert.Contains(t, files, "pods-web.yml")
+	assert.Contains(t, files, "pods-a_b.yml")
+}
@@ -16,9 +16,42 @@ limitations under the License.
 
 package main
 
+import (
+	"strconv"
+	"strings"
+)
+
 func newTarget() *Targets {
 	return &Targets{
 		Targets: make([]string, 0),
 		Labels:  make(map[string]string, 0),
 	}
 }
+
+// perEndpointGroups checks if each entry of the metrics annotation has its own target group
+func perEndpointGroups() bool {
+	return getConfig().EndpointLabel != "" || strings.Contains(getConfig().PodsFilePattern, patternEndpoint)
+}
+
+// splitMetrics splits the metrics entries into the target groups they produce, either a single group
+// for all of the entries or a group per entry
+func splitMetrics(metrics []*Metrics) [][]*Metrics {
+	if !perEndpointGroups() {
+		return [][]*Metrics{metrics}
+	}
+	var groups [][]*Metrics
+	for _, metric := range metrics {
+		groups = append(groups, []*Metrics{metric})
+	}
+
+	return groups
+}
+
+// metricName returns the name of the metrics entry, defaulting to the port when not named
+func metricName(metric *Metrics) string {
+	if metric.Name != "" {
+		return metric.Name
+	}
+
+	return strconv.Itoa(metric.Port)
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/golang/glog"
+)
+
+// templateData is the data passed to the user templates
+type templateData struct {
+	// the nodes discovered
+	Nodes []*Node
+	// the running pods discovered
+	Pods []*Pod
+	// the target groups generated, keyed by pods and nodes
+	Targets map[string][]*Targets
+	// the metrics of each of the services
+	services map[serviceKey][]*Metrics
+}
+
+// outputTemplate is a user template and the file it is rendered into
+type outputTemplate struct {
+	// the path of the template
+	source string
+	// the file the template is rendered into, relative to the config directory
+	filename string
+	// the parsed template
+	tmpl *template.Template
+}
+
+// templateFuncs are the helper functions available to the user templates
+var templateFuncs = template.FuncMap{
+	"join":     strings.Join,
+	"keys":     sortedKeys,
+	"sanitize": sanitizeLabelName,
+	"sort":     sortStrings,
+}
+
+// loadTemplates parses the template options, i.e. source:filename and loads the templates
+func loadTemplates(options []string) ([]*outputTemplate, error) {
+	var list []*outputTemplate
+	for _, option := range options {
+		source, filename, err := parseTemplateOption(option)
+		if err != nil {
+			return nil, err
+		}
+		tmpl, err := template.New(filepath.Base(source)).Funcs(templateFuncs).ParseFiles(source)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse the template: %s, error: %s", source, err)
+		}
+		glog.V(3).Infof("loaded the template: %s, rendering to: %s", source, filename)
+
+		list = append(list, &outputTemplate{
+			source:   source,
+			filename: filename,
+			tmpl:     tmpl,
+		})
+	}
+
+	return list, nil
+}
+
+// parseTemplateOption splits the template option into the template and the file it is rendered into
+func parseTemplateOption(option string) (string, string, error) {
+	index := strings.LastIndex(option, ":")
+	if index <= 0 || index == len(option)-1 {
+		return "", "", fmt.Errorf("invalid template: %s, should be in the format of template:filename", option)
+	}
+
+	return option[:index], option[index+1:], nil
+}
+
+// render executes the template and writes the output file, we return true if the content changed
+func (r *outputTemplate) render(data *templateData, sink outputSink) (bool, error) {
+	content := new(bytes.Buffer)
+	if err := r.tmpl.Execute(content, data); err != nil {
+		return false, fmt.Errorf("unable to execute the template, error: %s", err)
+	}
+
+	return sink.write(r.filename, content.Bytes())
+}
+
+// sortStrings returns a sorted copy of the list
+func sortStrings(list []string) []string {
+	sorted := append([]string(nil), list...)
+	sort.Strings(sorted)
+
+	return sorted
+}
+
+// sortedKeys returns the sorted keys of the map
+func sortedKeys(items map[string]string) []string {
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTemplateOption(t *testing.T) {
+	source, filename, err := parseTemplateOption("/etc/templates/upstreams.tmpl:upstreams.conf")
+	assert.Nil(t, err)
+	assert.Equal(t, "/etc/templates/upstreams.tmpl", source)
+	assert.Equal(t, "upstreams.conf", filename)
+
+	for _, option := range []string{"", "upstreams.tmpl", ":upstreams.conf", "upstreams.tmpl:"} {
+		_, _, err := parseTemplateOption(option)
+		assert.NotNil(t, err, "option: %s should have failed", option)
+	}
+}
+
+func TestLoadTemplates(t *testing.T) {
+	templates, err := loadTemplates([]string{"testdata/upstreams.tmpl:upstreams.conf"})
+	assert.Nil(t, err)
+	assert.Len(t, templates, 1)
+
+	_, err = loadTemplates([]string{"testdata/missing.tmpl:missing.conf"})
+	assert.NotNil(t, err)
+}
+
+func TestRenderTemplate(t *testing.T) {
+	directory, err := ioutil.TempDir("", "templates")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+	sink := &fileSink{directory: directory}
+
+	templates, err := loadTemplates([]string{"testdata/upstreams.tmpl:upstreams.conf"})
+	assert.Nil(t, err)
+
+	target := newTarget()
+	target.Targets = []string{"10.0.0.2:80", "10.0.0.1:80"}
+	target.Labels["namespace"] = "default"
+	target.Labels["pod"] = "web-app"
+	data := &templateData{Targets: map[string][]*Targets{"pods": {target}}}
+
+	changed, err := templates[0].render(data, sink)
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	changed, err = templates[0].render(data, sink)
+	assert.Nil(t, err)
+	assert.False(t, changed)
+	content, err := ioutil.ReadFile(filepath.Join(directory, "upstreams.conf"))
+	assert.Nil(t, err)
+	assert.Equal(t, "\n# default/web-app\nupstream web_app {\n  server 10.0.0.1:80;\n  server 10.0.0.2:80;\n}\n", string(content))
+}
+
+func TestSortedKeys(t *testing.T) {
+	assert.Equal(t, []string{"a", "b", "c"}, sortedKeys(map[string]string{"c": "", "a": "", "b": ""}))
+	assert.Equal(t, []string{"a", "b"}, sortStrings([]string{"b", "a"}))
+}
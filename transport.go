@@ -0,0 +1,220 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/client/unversioned"
+)
+
+// bearerTokenFileRoundTripper adds the token to the requests, the file is re-read when it has
+// been modified, so rotated tokens are picked up without a restart
+type bearerTokenFileRoundTripper struct {
+	sync.Mutex
+	// the file containing the token
+	filename string
+	// the current token
+	token string
+	// the modification time of the file when last read
+	modified time.Time
+	// the underlining transport
+	rt http.RoundTripper
+}
+
+// bearerTokenRoundTripper adds a static token to the requests
+type bearerTokenRoundTripper struct {
+	// the token
+	token string
+	// the underlining transport
+	rt http.RoundTripper
+}
+
+// basicAuthRoundTripper adds the basic authentication to the requests
+type basicAuthRoundTripper struct {
+	// the username
+	username string
+	// the password
+	password string
+	// the underlining transport
+	rt http.RoundTripper
+}
+
+// newTransport creates the transport used by the kubernetes client from the client configuration; the
+// tls and authentication options are moved from the configuration into the transport
+func newTransport(cfg *unversioned.Config, tokenFile string) (http.RoundTripper, error) {
+	tlsConfig, err := newTLSConfig(cfg, getConfig().TLSServerName)
+	if err != nil {
+		return nil, err
+	}
+
+	var rt http.RoundTripper = &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+
+	// step: wrap the transport with the authentication
+	switch {
+	case tokenFile != "":
+		rt, err = newBearerTokenFileRoundTripper(tokenFile, rt)
+		if err != nil {
+			return nil, err
+		}
+	case cfg.BearerToken != "":
+		rt = &bearerTokenRoundTripper{token: cfg.BearerToken, rt: rt}
+	case cfg.Username != "":
+		rt = &basicAuthRoundTripper{username: cfg.Username, password: cfg.Password, rt: rt}
+	}
+
+	return rt, nil
+}
+
+// newTLSConfig creates the tls configuration from the client configuration
+func newTLSConfig(cfg *unversioned.Config, serverName string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.Insecure,
+		ServerName:         serverName,
+	}
+
+	// step: load the certificate authority if any, else we use the system roots
+	caData := cfg.TLSClientConfig.CAData
+	if cfg.TLSClientConfig.CAFile != "" {
+		content, err := ioutil.ReadFile(cfg.TLSClientConfig.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the ca file: %s, error: %s", cfg.TLSClientConfig.CAFile, err)
+		}
+		caData = content
+	}
+	if len(caData) > 0 {
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("unable to find any certificates in the ca")
+		}
+	}
+
+	// step: load the client certificate if any
+	certData, keyData := cfg.TLSClientConfig.CertData, cfg.TLSClientConfig.KeyData
+	if cfg.TLSClientConfig.CertFile != "" || cfg.TLSClientConfig.KeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(cfg.TLSClientConfig.CertFile, cfg.TLSClientConfig.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load the client certificate: %s, error: %s", cfg.TLSClientConfig.CertFile, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	} else if len(certData) > 0 || len(keyData) > 0 {
+		certificate, err := tls.X509KeyPair(certData, keyData)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load the client certificate, error: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
+
+// newBearerTokenFileRoundTripper creates a new token file round tripper
+func newBearerTokenFileRoundTripper(filename string, rt http.RoundTripper) (*bearerTokenFileRoundTripper, error) {
+	r := &bearerTokenFileRoundTripper{
+		filename: filename,
+		rt:       rt,
+	}
+	if _, err := r.getToken(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// RoundTrip adds the token to the request
+func (r *bearerTokenFileRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := r.getToken()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.rt.RoundTrip(withHeader(req, "Authorization", "Bearer "+token))
+}
+
+// getToken retrieves the token, re-reading the file if it has changed
+func (r *bearerTokenFileRoundTripper) getToken() (string, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	stat, err := os.Stat(r.filename)
+	if err != nil {
+		if r.token != "" {
+			glog.Warningf("unable to check the token file: %s, using the previous token, error: %s", r.filename, err)
+			return r.token, nil
+		}
+		return "", fmt.Errorf("the token file: %s does not exist", r.filename)
+	}
+	if r.token != "" && stat.ModTime().Equal(r.modified) {
+		return r.token, nil
+	}
+
+	content, err := ioutil.ReadFile(r.filename)
+	if err != nil {
+		if r.token != "" {
+			glog.Warningf("unable to read the token file: %s, using the previous token, error: %s", r.filename, err)
+			return r.token, nil
+		}
+		return "", fmt.Errorf("unable to read the token file: %s, error: %s", r.filename, err)
+	}
+	if r.token != "" {
+		glog.Infof("the token file: %s has changed, reloading the token", r.filename)
+	}
+	r.token = strings.TrimSpace(string(content))
+	r.modified = stat.ModTime()
+
+	return r.token, nil
+}
+
+// RoundTrip adds the token to the request
+func (r *bearerTokenRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return r.rt.RoundTrip(withHeader(req, "Authorization", "Bearer "+r.token))
+}
+
+// RoundTrip adds the basic authentication to the request
+func (r *basicAuthRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = withHeader(req, "Authorization", "")
+	req.SetBasicAuth(r.username, r.password)
+
+	return r.rt.RoundTrip(req)
+}
+
+// withHeader returns a copy of the request with the header set, a round tripper should
+// not modify the original request
+func withHeader(req *http.Request, name, value string) *http.Request {
+	clone := new(http.Request)
+	*clone = *req
+	clone.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		clone.Header[k] = append([]string(nil), v...)
+	}
+	clone.Header.Set(name, value)
+
+	return clone
+}
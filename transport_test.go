@@ -0,0 +1,103 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/client/unversioned"
+)
+
+func newTestAuthServer(t *testing.T) (*httptest.Server, *string) {
+	var header string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		header = req.Header.Get("Authorization")
+	}))
+
+	return server, &header
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	cfg := &unversioned.Config{Insecure: true}
+	tlsConfig, err := newTLSConfig(cfg, "kubernetes.default")
+	assert.Nil(t, err)
+	assert.True(t, tlsConfig.InsecureSkipVerify)
+	assert.Equal(t, "kubernetes.default", tlsConfig.ServerName)
+	assert.Nil(t, tlsConfig.RootCAs)
+
+	cfg = &unversioned.Config{}
+	cfg.TLSClientConfig.CAFile = "/does/not/exist"
+	_, err = newTLSConfig(cfg, "")
+	assert.NotNil(t, err)
+
+	cfg = &unversioned.Config{}
+	cfg.TLSClientConfig.CAData = []byte("not a certificate")
+	_, err = newTLSConfig(cfg, "")
+	assert.NotNil(t, err)
+}
+
+func TestBearerTokenFileRoundTripper(t *testing.T) {
+	server, header := newTestAuthServer(t)
+	defer server.Close()
+
+	file, err := ioutil.TempFile("", "token")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+	assert.Nil(t, ioutil.WriteFile(file.Name(), []byte("first\n"), 0600))
+
+	rt, err := newBearerTokenFileRoundTripper(file.Name(), http.DefaultTransport)
+	assert.Nil(t, err)
+	client := &http.Client{Transport: rt}
+
+	_, err = client.Get(server.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer first", *header)
+
+	// step: rotate the token
+	assert.Nil(t, ioutil.WriteFile(file.Name(), []byte("second\n"), 0600))
+	modified := time.Now().Add(time.Minute)
+	assert.Nil(t, os.Chtimes(file.Name(), modified, modified))
+
+	_, err = client.Get(server.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer second", *header)
+
+	// step: the previous token is used if the file disappears
+	os.Remove(file.Name())
+	_, err = client.Get(server.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer second", *header)
+
+	_, err = newBearerTokenFileRoundTripper("/does/not/exist", http.DefaultTransport)
+	assert.NotNil(t, err)
+}
+
+func TestBasicAuthRoundTripper(t *testing.T) {
+	server, header := newTestAuthServer(t)
+	defer server.Close()
+
+	client := &http.Client{Transport: &basicAuthRoundTripper{username: "user", password: "pass", rt: http.DefaultTransport}}
+	_, err := client.Get(server.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, "Basic dXNlcjpwYXNz", *header)
+}
@@ -17,9 +17,13 @@ limitations under the License.
 package main
 
 import (
-	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/api"
 )
 
 // getEnvString get the value from the environment or use the default
@@ -44,23 +48,87 @@ func getEnvInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
-// writeConfigFile write the contents to stdout of a file
-func writeConfigFile(content []byte, directory, filename string, dryRun bool) (err error) {
-	var file *os.File
+// getNamespaces returns the list of namespaces we are configured for, if none have been
+// given we return api.NamespaceAll i.e. all the namespaces
+func getNamespaces() []string {
+	var list []string
+	found := make(map[string]bool, 0)
+	for _, namespace := range strings.Split(getConfig().Namespaces, ",") {
+		namespace = strings.TrimSpace(namespace)
+		if namespace == "" || found[namespace] {
+			continue
+		}
+		found[namespace] = true
+		list = append(list, namespace)
+	}
+	if len(list) <= 0 {
+		return []string{api.NamespaceAll}
+	}
 
-	if dryRun {
-		file = os.Stdout
+	return list
+}
+
+// sanitizeLabelName converts the name into a valid prometheus label name, any invalid
+// characters are replaced with an underscore
+func sanitizeLabelName(name string) string {
+	sanitized := []byte(name)
+	for i, c := range sanitized {
+		valid := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9')
+		if !valid {
+			sanitized[i] = '_'
+		}
 	}
-	if !dryRun {
-		file, err = os.Open(fmt.Sprintf("%s/%s", directory, filename))
-		if err != nil {
-			return
+
+	return string(sanitized)
+}
+
+// sanitizeFilename converts the value into one safe to use within a filename, any characters
+// other than letters, digits, dashes, underscores and dots are replaced with an underscore
+func sanitizeFilename(value string) string {
+	sanitized := []byte(value)
+	for i, c := range sanitized {
+		valid := c == '-' || c == '_' || c == '.' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+		if !valid {
+			sanitized[i] = '_'
 		}
 	}
 
+	return string(sanitized)
+}
+
+// isValidFilename checks the sanitized value can be used within a filename
+func isValidFilename(value string) bool {
+	return value != "" && value != "." && value != ".."
+}
+
+// fileExists checks if the file exists
+func fileExists(filename string) bool {
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return false
+	}
+
+	return true
+}
+
+// writeFileAtomic writes the content to a temporary file in the same directory and renames it
+func writeFileAtomic(filename string, content []byte) error {
+	file, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".")
+	if err != nil {
+		return err
+	}
+	// step: ensure we cleanup the temporary file on failure
+	defer os.Remove(file.Name())
+
 	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(file.Name(), 0644); err != nil {
 		return err
 	}
 
-	return nil
+	return os.Rename(file.Name(), filename)
 }
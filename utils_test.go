@@ -17,7 +17,9 @@ limitations under the License.
 package main
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -36,3 +38,58 @@ func TestGetEnvInt(t *testing.T) {
 	assert.Equal(t, value, 10)
 	os.Setenv("TEST_NUMBER", "10")
 }
+
+func TestFileExists(t *testing.T) {
+	assert.True(t, fileExists("utils.go"))
+	assert.False(t, fileExists("/does/not/exist"))
+}
+
+func TestGetNamespaces(t *testing.T) {
+	defer func(namespaces string) { getConfig().Namespaces = namespaces }(getConfig().Namespaces)
+
+	getConfig().Namespaces = ""
+	assert.Equal(t, []string{""}, getNamespaces())
+	getConfig().Namespaces = "default, platform,default"
+	assert.Equal(t, []string{"default", "platform"}, getNamespaces())
+	getConfig().Namespaces = "default,,platform,"
+	assert.Equal(t, []string{"default", "platform"}, getNamespaces())
+	getConfig().Namespaces = " , "
+	assert.Equal(t, []string{""}, getNamespaces())
+}
+
+func TestSanitizeLabelName(t *testing.T) {
+	assert.Equal(t, "app_kubernetes_io_name", sanitizeLabelName("app.kubernetes.io/name"))
+	assert.Equal(t, "_name", sanitizeLabelName("9name"))
+	assert.Equal(t, "name_1", sanitizeLabelName("name-1"))
+	assert.Equal(t, "", sanitizeLabelName(""))
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	assert.Equal(t, "web-1.v2_metrics", sanitizeFilename("web-1.v2_metrics"))
+	assert.Equal(t, ".._.._etc_passwd", sanitizeFilename("../../etc/passwd"))
+	assert.Equal(t, "a_b_c", sanitizeFilename("a b*c"))
+	assert.True(t, isValidFilename("web"))
+	assert.False(t, isValidFilename(""))
+	assert.False(t, isValidFilename("."))
+	assert.False(t, isValidFilename(".."))
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	directory, err := ioutil.TempDir("", "atomic")
+	assert.Nil(t, err)
+	defer os.RemoveAll(directory)
+
+	filename := filepath.Join(directory, "pods.yml")
+	assert.Nil(t, writeFileAtomic(filename, []byte("first")))
+	assert.Nil(t, writeFileAtomic(filename, []byte("second")))
+	content, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, "second", string(content))
+
+	// step: ensure no temporary files are left behind
+	files, err := ioutil.ReadDir(directory)
+	assert.Nil(t, err)
+	assert.Len(t, files, 1)
+
+	assert.NotNil(t, writeFileAtomic("/does/not/exist/pods.yml", []byte("test")))
+}
@@ -0,0 +1,399 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// documentSeparatorRegex matches the separator between the documents of a yaml file
+var documentSeparatorRegex = regexp.MustCompile(`^---\s*$`)
+
+// manifestError is a problem found in a manifest, with the location of it
+type manifestError struct {
+	// the file the error was found in
+	filename string
+	// the line the error was found on
+	line int
+	// the description of the error
+	message string
+}
+
+// manifestDocument is a single document from a manifest file
+type manifestDocument struct {
+	// the line the document starts on
+	line int
+	// the content of the document
+	content []byte
+}
+
+// manifestContainer is the part of a container spec we need to validate
+type manifestContainer struct {
+	// the name of the container
+	Name string `yaml:"name"`
+	// the ports exposed by the container
+	Ports []struct {
+		ContainerPort int `yaml:"containerPort"`
+	} `yaml:"ports"`
+}
+
+// manifestPodSpec is the part of a pod spec we need to validate
+type manifestPodSpec struct {
+	// the containers of the pod
+	Containers []manifestContainer `yaml:"containers"`
+}
+
+// manifestMetadata is the part of the metadata we need
+type manifestMetadata struct {
+	// the name of the resource
+	Name string `yaml:"name"`
+	// the namespace of the resource
+	Namespace string `yaml:"namespace"`
+	// the labels of the resource
+	Labels map[string]string `yaml:"labels"`
+	// the annotations of the resource
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+// manifestObject is the part of a kubernetes resource we need, either a pod, a node, a namespace,
+// a resource with a pod template or a list of resources
+type manifestObject struct {
+	// the kind of resource
+	Kind string `yaml:"kind"`
+	// the metadata of the resource
+	Metadata manifestMetadata `yaml:"metadata"`
+	// the spec of the resource
+	Spec struct {
+		// the containers of a pod
+		Containers []manifestContainer `yaml:"containers"`
+		// the node the pod is running on
+		NodeName string `yaml:"nodeName"`
+		// the pod template of the resource
+		Template struct {
+			Metadata manifestMetadata `yaml:"metadata"`
+			Spec     manifestPodSpec  `yaml:"spec"`
+		} `yaml:"template"`
+	} `yaml:"spec"`
+	// the status of a pod
+	Status struct {
+		// the phase of the pod
+		Phase string `yaml:"phase"`
+		// the address of the pod
+		PodIP string `yaml:"podIP"`
+	} `yaml:"status"`
+	// the items of a list
+	Items []*manifestObject `yaml:"items"`
+}
+
+// String returns the error in the file:line: message format
+func (r *manifestError) String() string {
+	return fmt.Sprintf("%s:%d: %s", r.filename, r.line, r.message)
+}
+
+// validateCommand is the validate subcommand, it lints the metrics annotations of the pod templates
+// within the manifests and returns the exit code
+func validateCommand(args []string, output io.Writer) int {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	flags.SetOutput(output)
+	annotation := flags.String("metrics", "metrics", "the tag used in the pods annotations")
+	flags.Usage = func() {
+		fmt.Fprintf(output, "usage: %s validate [options] <file or directory>...\n", filepath.Base(os.Args[0]))
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	errs, err := validateManifests(flags.Args(), *annotation)
+	if err != nil {
+		fmt.Fprintf(output, "error: %s\n", err)
+		return 1
+	}
+	for _, e := range errs {
+		fmt.Fprintln(output, e)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// validateManifests validates the manifests within the files and directories
+func validateManifests(paths []string, annotation string) ([]*manifestError, error) {
+	var files []string
+	for _, path := range paths {
+		found, err := findManifests(path, true)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, found...)
+	}
+	sort.Strings(files)
+
+	var list []*manifestError
+	for _, filename := range files {
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, validateManifest(filename, content, annotation)...)
+	}
+
+	return list, nil
+}
+
+// findManifests returns the manifest files at the path, directories are walked and symlinks followed; files
+// given explicitly are always included, while only manifest extensions are picked up from directories
+func findManifests(path string, explicit bool) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		switch filepath.Ext(path) {
+		case ".yml", ".yaml", ".json":
+		default:
+			if !explicit {
+				return nil, nil
+			}
+		}
+		return []string{path}, nil
+	}
+
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []string
+	for _, file := range files {
+		found, err := findManifests(filepath.Join(path, file.Name()), false)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, found...)
+	}
+
+	return list, nil
+}
+
+// validateManifest validates the metrics annotations within the documents of a manifest
+func validateManifest(filename string, content []byte, annotation string) []*manifestError {
+	var list []*manifestError
+	for _, document := range splitDocuments(content) {
+		object := new(manifestObject)
+		if err := yaml.Unmarshal(document.content, object); err != nil {
+			list = append(list, &manifestError{filename, document.line, fmt.Sprintf("unable to decode the document, error: %s", err)})
+			continue
+		}
+
+		// step: find the annotations within the document, so we can report the line of each
+		lines := findAnnotationLines(document.content, annotation)
+		index := 0
+		for _, pod := range podTemplates(object) {
+			value, found := pod.Metadata.Annotations[annotation]
+			if !found {
+				continue
+			}
+			line := document.line
+			if index < len(lines) {
+				line = document.line + lines[index]
+			}
+			index++
+
+			for _, message := range validateMetricsAnnotation(value, pod.Spec) {
+				list = append(list, &manifestError{filename, line, fmt.Sprintf("%s: %s", pod.Metadata.Name, message)})
+			}
+		}
+	}
+
+	return list
+}
+
+// podTemplate is the metadata and spec of a pod, either a pod or the template of a resource
+type podTemplate struct {
+	// the metadata of the pod
+	Metadata manifestMetadata
+	// the spec of the pod
+	Spec manifestPodSpec
+}
+
+// podTemplates extracts the pods and pod templates from the object
+func podTemplates(object *manifestObject) []*podTemplate {
+	var list []*podTemplate
+	switch object.Kind {
+	case "List":
+		for _, item := range object.Items {
+			list = append(list, podTemplates(item)...)
+		}
+	case "Pod":
+		list = append(list, &podTemplate{Metadata: object.Metadata, Spec: manifestPodSpec{Containers: object.Spec.Containers}})
+	case "ReplicationController", "ReplicaSet", "Deployment", "DaemonSet", "Job", "PetSet", "StatefulSet":
+		pod := &podTemplate{Metadata: object.Spec.Template.Metadata, Spec: object.Spec.Template.Spec}
+		if pod.Metadata.Name == "" {
+			pod.Metadata.Name = object.Metadata.Name
+		}
+		list = append(list, pod)
+	}
+
+	return list
+}
+
+// validateMetricsAnnotation checks the metrics annotation against the schema and the containers of the pod
+func validateMetricsAnnotation(annotation string, spec manifestPodSpec) []string {
+	var metrics []*Metrics
+	if err := yaml.UnmarshalStrict([]byte(annotation), &metrics); err != nil {
+		return []string{fmt.Sprintf("invalid metrics annotation, error: %s", err)}
+	}
+	if len(metrics) == 0 {
+		return []string{"the metrics annotation has no entries"}
+	}
+
+	// step: gather the ports exposed by the containers
+	ports := make(map[int]bool, 0)
+	for _, container := range spec.Containers {
+		for _, port := range container.Ports {
+			ports[port.ContainerPort] = true
+		}
+	}
+
+	var list []string
+	seenPorts := make(map[int]bool, 0)
+	seenNames := make(map[string]bool, 0)
+	for i, metric := range metrics {
+		entry := fmt.Sprintf("entry %d", i+1)
+		if metric.Name != "" {
+			entry = fmt.Sprintf("entry %d (%s)", i+1, metric.Name)
+		}
+		duplicatePort := seenPorts[metric.Port]
+		switch {
+		case metric.Port <= 0 || metric.Port > 65535:
+			list = append(list, fmt.Sprintf("%s: invalid port: %d", entry, metric.Port))
+		case duplicatePort:
+			list = append(list, fmt.Sprintf("%s: duplicate port: %d", entry, metric.Port))
+		case !ports[metric.Port]:
+			list = append(list, fmt.Sprintf("%s: the port: %d is not exposed by any of the containers", entry, metric.Port))
+		}
+		seenPorts[metric.Port] = true
+
+		// check: the entries are named by the name or port, which must be unique as the scrape jobs
+		// are named after them
+		name := metricName(metric)
+		if seenNames[name] && (metric.Name != "" || !duplicatePort) {
+			list = append(list, fmt.Sprintf("%s: duplicate name: %s", entry, name))
+		}
+		seenNames[name] = true
+		if metric.Endpoint != "" {
+			if u, err := url.Parse(metric.Endpoint); err != nil || !strings.HasPrefix(metric.Endpoint, "/") || u.Path != metric.Endpoint {
+				list = append(list, fmt.Sprintf("%s: invalid endpoint: %s, must be an absolute path", entry, metric.Endpoint))
+			}
+		}
+		switch metric.Scheme {
+		case "", "http", "https":
+		default:
+			list = append(list, fmt.Sprintf("%s: invalid scheme: %s, must be http or https", entry, metric.Scheme))
+		}
+		if metric.Interval != "" && !durationRegex.MatchString(metric.Interval) {
+			list = append(list, fmt.Sprintf("%s: invalid interval: %s", entry, metric.Interval))
+		}
+		if metric.Timeout != "" && !durationRegex.MatchString(metric.Timeout) {
+			list = append(list, fmt.Sprintf("%s: invalid timeout: %s", entry, metric.Timeout))
+		} else if metric.Timeout != "" && metric.Interval != "" && durationRegex.MatchString(metric.Interval) {
+			interval, _ := durationSeconds(metric.Interval)
+			timeout, _ := durationSeconds(metric.Timeout)
+			if timeout > interval {
+				list = append(list, fmt.Sprintf("%s: the timeout: %s is greater than the interval: %s", entry, metric.Timeout, metric.Interval))
+			}
+		}
+		if metric.Alerts != nil {
+			for _, problem := range validateAlerts(metric) {
+				list = append(list, fmt.Sprintf("%s: %s", entry, problem))
+			}
+		}
+	}
+
+	return list
+}
+
+// splitDocuments splits the content into the yaml documents, recording the line each starts on
+func splitDocuments(content []byte) []*manifestDocument {
+	var list []*manifestDocument
+	current := &manifestDocument{line: 1}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		if documentSeparatorRegex.MatchString(scanner.Text()) {
+			list = append(list, current)
+			current = &manifestDocument{line: line + 1}
+			continue
+		}
+		current.content = append(current.content, scanner.Bytes()...)
+		current.content = append(current.content, '\n')
+	}
+	list = append(list, current)
+
+	// step: remove any documents which are empty or only comments
+	var documents []*manifestDocument
+	for _, document := range list {
+		var object interface{}
+		if err := yaml.Unmarshal(document.content, &object); err == nil && object == nil {
+			continue
+		}
+		documents = append(documents, document)
+	}
+
+	return documents
+}
+
+// findAnnotationLines returns the offsets of the lines containing the annotation key within the
+// annotations of the document, in the order they appear
+func findAnnotationLines(content []byte, annotation string) []int {
+	keyRegex := regexp.MustCompile(`^\s*"?` + regexp.QuoteMeta(annotation) + `"?\s*:`)
+	annotationsRegex := regexp.MustCompile(`^\s*"?annotations"?\s*:`)
+
+	var list []int
+	indent := -1
+	for offset, line := range strings.Split(string(content), "\n") {
+		current := len(line) - len(strings.TrimLeft(line, " \t"))
+		switch {
+		case annotationsRegex.MatchString(line):
+			indent = current
+		case indent >= 0 && strings.TrimSpace(line) != "" && current <= indent:
+			indent = -1
+		case indent >= 0 && keyRegex.MatchString(line):
+			list = append(list, offset)
+		}
+	}
+
+	return list
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2014 Rohith All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestValidateManifests(t *testing.T) {
+	errs, err := validateManifests([]string{"testdata/manifests"}, "metrics")
+	assert.Nil(t, err)
+
+	var list []string
+	for _, e := range errs {
+		list = append(list, e.String())
+	}
+	assert.Equal(t, []string{
+		"testdata/manifests/invalid.yml:7: web: entry 1 (web): invalid endpoint: stats, must be an absolute path",
+		"testdata/manifests/invalid.yml:7: web: entry 2 (web): duplicate port: 8080",
+		"testdata/manifests/invalid.yml:7: web: entry 2 (web): duplicate name: web",
+		"testdata/manifests/invalid.yml:30: api: invalid metrics annotation, error: yaml: unmarshal errors:\n  line 2: field prot not found in type main.Metrics",
+		"testdata/manifests/invalid.yml:49: worker: entry 1: invalid scheme: ftp, must be http or https",
+		"testdata/manifests/invalid.yml:49: worker: entry 1: invalid interval: 10 seconds",
+		"testdata/manifests/invalid.yml:49: worker: entry 1: alerts: rule 1 (HighErrors): invalid expression: 1:26: parse error: unclosed left parenthesis",
+		"testdata/manifests/invalid.yml:49: worker: entry 1: alerts: target_down: invalid for: 5 minutes",
+	}, list)
+
+	_, err = validateManifests([]string{"testdata/does_not_exist"}, "metrics")
+	assert.NotNil(t, err)
+}
+
+func TestValidateServices(t *testing.T) {
+	errs, err := validateManifests([]string{"testdata/services"}, "metrics")
+	assert.Nil(t, err)
+	assert.Empty(t, errs)
+}
+
+func TestValidateCommand(t *testing.T) {
+	output := new(bytes.Buffer)
+	assert.Equal(t, 0, validateCommand([]string{"testdata/manifests/valid.json"}, output))
+	assert.Empty(t, output.String())
+	assert.Equal(t, 1, validateCommand([]string{"testdata/manifests/invalid.yml"}, output))
+	assert.Contains(t, output.String(), "invalid.yml:7:")
+	assert.Equal(t, 2, validateCommand([]string{}, new(bytes.Buffer)))
+}
+
+func TestValidateMetricsAnnotationJobs(t *testing.T) {
+	var spec manifestPodSpec
+	assert.Nil(t, yaml.Unmarshal([]byte("containers: [{ports: [{containerPort: 8080}, {containerPort: 9100}]}]"), &spec))
+	problems := validateMetricsAnnotation("- name: \"9100\"\n  port: 8080\n  interval: 10s\n  timeout: 30s\n- port: 9100\n", spec)
+	assert.Equal(t, []string{
+		"entry 1 (9100): the timeout: 30s is greater than the interval: 10s",
+		"entry 2: duplicate name: 9100",
+	}, problems)
+}
+
+func TestSplitDocuments(t *testing.T) {
+	documents := splitDocuments([]byte("# comment\n---\nkind: Pod\n---\n\n---\nkind: Service\n"))
+	assert.Len(t, documents, 2)
+	assert.Equal(t, 3, documents[0].line)
+	assert.Equal(t, 7, documents[1].line)
+}